@@ -0,0 +1,261 @@
+package expo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ApplyDefaults fills the empty fields of each message from defaults, in place.
+// Only fields whose zero value cannot be an explicit choice are filled:
+// Body, Sound, Title, Subtitle, Priority, ChannelID, CategoryID,
+// TargetContentID, APNSCollapseID, AndroidCollapseKey, Data, Expiration,
+// and TTLSeconds, Badge and DisplayInForeground when nil. An explicit zero
+// TTL, badge or foreground display is kept. To, MutableContent and
+// ContentAvailable are never touched since a zero value there may be
+// intentional.
+func ApplyDefaults(messages []PushMessage, defaults PushMessage) {
+	for i := range messages {
+		m := &messages[i]
+		if m.Body == "" {
+			m.Body = defaults.Body
+		}
+		if m.Data == nil {
+			m.Data = defaults.Data
+		}
+		if m.Sound == "" {
+			m.Sound = defaults.Sound
+		}
+		if m.Title == "" {
+			m.Title = defaults.Title
+		}
+		if m.Subtitle == "" {
+			m.Subtitle = defaults.Subtitle
+		}
+		if m.TTLSeconds == nil {
+			m.TTLSeconds = defaults.TTLSeconds
+		}
+		if m.Expiration == 0 {
+			m.Expiration = defaults.Expiration
+		}
+		if m.Priority == "" {
+			m.Priority = defaults.Priority
+		}
+		if m.Badge == nil {
+			m.Badge = defaults.Badge
+		}
+		if m.ChannelID == "" {
+			m.ChannelID = defaults.ChannelID
+		}
+		if m.CategoryID == "" {
+			m.CategoryID = defaults.CategoryID
+		}
+		if m.DisplayInForeground == nil {
+			m.DisplayInForeground = defaults.DisplayInForeground
+		}
+		if m.TargetContentID == "" {
+			m.TargetContentID = defaults.TargetContentID
+		}
+		if m.APNSCollapseID == "" {
+			m.APNSCollapseID = defaults.APNSCollapseID
+		}
+		if m.AndroidCollapseKey == "" {
+			m.AndroidCollapseKey = defaults.AndroidCollapseKey
+		}
+	}
+}
+
+// Partition groups a batch of responses by outcome
+type Partition struct {
+	OK       []PushResponse
+	Failed   []PushResponse
+	Prunable []PushResponse // Stale tokens, only populated with PruneUnregistered
+}
+
+// PartitionOptions controls how responses are classified
+type PartitionOptions struct {
+	// PruneUnregistered classifies DeviceNotRegistered responses as Prunable
+	// rather than Failed, since a stale token is an expected cleanup rather
+	// than a delivery failure worth alerting on.
+	PruneUnregistered bool
+}
+
+// PartitionResponses groups responses into OK, Failed and Prunable
+func PartitionResponses(responses []PushResponse, opts PartitionOptions) Partition {
+	var p Partition
+	for _, r := range responses {
+		err := r.ValidateResponse()
+		if err == nil {
+			p.OK = append(p.OK, r)
+			continue
+		}
+		if _, ok := err.(*DeviceNotRegisteredError); ok && opts.PruneUnregistered {
+			p.Prunable = append(p.Prunable, r)
+			continue
+		}
+		p.Failed = append(p.Failed, r)
+	}
+	return p
+}
+
+// AllOK reports whether none of the responses failed
+func AllOK(responses []PushResponse, opts PartitionOptions) bool {
+	return len(PartitionResponses(responses, opts).Failed) == 0
+}
+
+// CollectUnregisteredTokens returns the tokens of the responses which failed
+// with DeviceNotRegistered, in order and without duplicates, so that they can
+// be removed from storage
+func CollectUnregisteredTokens(responses []PushResponse) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	for _, r := range responses {
+		if !r.ShouldRemoveToken() {
+			continue
+		}
+		for _, to := range r.PushMessage.To {
+			if !seen[to] {
+				seen[to] = true
+				tokens = append(tokens, to)
+			}
+		}
+	}
+	return tokens
+}
+
+// WriteUnregisteredTokens writes the tokens returned by
+// CollectUnregisteredTokens to w, one per line, for piping into a cleanup job
+func WriteUnregisteredTokens(w io.Writer, responses []PushResponse) error {
+	for _, token := range CollectUnregisteredTokens(responses) {
+		if _, err := io.WriteString(w, token+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SplitByPriority groups messages by their Priority so each group can be sent
+// through a differently paced client. Messages without a priority are grouped
+// under the empty string. The order of messages within a group is preserved.
+func SplitByPriority(messages []PushMessage) map[string][]PushMessage {
+	groups := make(map[string][]PushMessage)
+	for _, m := range messages {
+		groups[m.Priority] = append(groups[m.Priority], m)
+	}
+	return groups
+}
+
+// MaxRecipientsPerMessage is the maximum number of recipients Expo accepts in a single message
+const MaxRecipientsPerMessage = 100
+
+// NormalizeRecipients splits each message with more than MaxRecipientsPerMessage
+// recipients into consecutive messages of at most that many, preserving all other
+// fields and the overall recipient order, so responses still map one per token.
+// The input is returned unchanged if no message needs splitting.
+func NormalizeRecipients(messages []PushMessage) []PushMessage {
+	return splitRecipients(messages, MaxRecipientsPerMessage)
+}
+
+// splitRecipients splits each message with more than size recipients into
+// consecutive messages of at most size, returning messages unchanged if none
+// needs splitting
+func splitRecipients(messages []PushMessage, size int) []PushMessage {
+	split := false
+	for _, m := range messages {
+		if len(m.To) > size {
+			split = true
+			break
+		}
+	}
+	if !split {
+		return messages
+	}
+	var normalized []PushMessage
+	for _, m := range messages {
+		to := m.To
+		for len(to) > size {
+			part := m
+			part.To = to[:size]
+			normalized = append(normalized, part)
+			to = to[size:]
+		}
+		m.To = to
+		normalized = append(normalized, m)
+	}
+	return normalized
+}
+
+// PersonalizedMessages builds one message per token from template, with the
+// token's data merged over the template's Data. Messages are ordered by token.
+// Tokens failing validation are skipped and their errors joined in the returned error.
+func PersonalizedMessages(perToken map[string]map[string]interface{}, template PushMessage) ([]PushMessage, error) {
+	tokens := make([]string, 0, len(perToken))
+	for token := range perToken {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	var messages []PushMessage
+	var errs []error
+	for _, token := range tokens {
+		if _, err := NewExponentPushToken(token); err != nil {
+			errs = append(errs, fmt.Errorf("token %q: %w", token, err))
+			continue
+		}
+		m := template
+		m.To = []string{token}
+		m.Data = make(map[string]interface{}, len(template.Data)+len(perToken[token]))
+		for k, v := range template.Data {
+			m.Data[k] = v
+		}
+		for k, v := range perToken[token] {
+			m.Data[k] = v
+		}
+		messages = append(messages, m)
+	}
+	return messages, errors.Join(errs...)
+}
+
+// FingerprintExcludingTo returns a stable hash of the message's content,
+// excluding its recipients and Metadata, so that messages which differ only
+// in their tokens share a fingerprint. The hash is of the JSON encoding, in
+// which struct fields have a fixed order and map keys are sorted.
+func (m PushMessage) FingerprintExcludingTo() string {
+	m.To = nil
+	b, err := json.Marshal(m)
+	if err != nil {
+		// Data holds a value JSON can't encode; fall back to Go's formatting
+		b = []byte(fmt.Sprintf("%#v", m))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// GroupIdentical merges messages with the same content, as determined by
+// FingerprintExcludingTo, into multi-recipient messages of at most
+// MaxRecipientsPerMessage recipients, to reduce the size of broadcast sends.
+// Messages are only merged if their Metadata is also equal, so each response
+// still carries the Metadata of the message its token came from.
+// Merged messages are ordered by the first message of each group, with
+// recipients in their original order; responses still map one per token.
+func GroupIdentical(messages []PushMessage) []PushMessage {
+	var grouped []PushMessage
+	open := map[string]int{} // Index in grouped of the group still accepting recipients, by key
+	for _, m := range messages {
+		metadata, _ := json.Marshal(m.Metadata)
+		key := m.FingerprintExcludingTo() + string(metadata)
+		i, ok := open[key]
+		if !ok || len(grouped[i].To)+len(m.To) > MaxRecipientsPerMessage {
+			m.To = append([]string(nil), m.To...)
+			grouped = append(grouped, m)
+			open[key] = len(grouped) - 1
+			continue
+		}
+		grouped[i].To = append(grouped[i].To, m.To...)
+	}
+	return grouped
+}
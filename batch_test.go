@@ -0,0 +1,311 @@
+package expo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyDefaultsFillsEmptyFields(t *testing.T) {
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}, Body: "first"},
+		{To: []string{"ExponentPushToken[b]"}, Body: "second", Sound: "ping", Priority: HighPriority, DisplayInForeground: Bool(false)},
+	}
+	ApplyDefaults(messages, PushMessage{
+		Sound:               "default",
+		Priority:            NormalPriority,
+		ChannelID:           "alerts",
+		Subtitle:            "news",
+		DisplayInForeground: Bool(true),
+		TargetContentID:     "inbox",
+		APNSCollapseID:      "digest",
+		AndroidCollapseKey:  "digest",
+	})
+	if messages[0].Sound != "default" || messages[0].Priority != NormalPriority || messages[0].ChannelID != "alerts" {
+		t.Error("Didn't fill empty fields from defaults")
+	}
+	if messages[1].Sound != "ping" || messages[1].Priority != HighPriority {
+		t.Error("Overwrote explicitly set fields")
+	}
+	if messages[1].ChannelID != "alerts" {
+		t.Error("Didn't fill empty field on partially set message")
+	}
+	m := messages[0]
+	if m.Subtitle != "news" || m.DisplayInForeground == nil || !*m.DisplayInForeground ||
+		m.TargetContentID != "inbox" || m.APNSCollapseID != "digest" || m.AndroidCollapseKey != "digest" {
+		t.Errorf("Didn't fill the later fields from defaults: %+v", m)
+	}
+	if *messages[1].DisplayInForeground {
+		t.Error("Overwrote an explicit false DisplayInForeground")
+	}
+	if messages[0].Body != "first" || messages[0].To[0] != "ExponentPushToken[a]" {
+		t.Error("Overwrote message content")
+	}
+}
+
+func TestApplyDefaultsKeepsZeroAmbiguousFields(t *testing.T) {
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}, Badge: Int(0), TTLSeconds: Int(0)},
+		{To: []string{"ExponentPushToken[c]"}},
+	}
+	ApplyDefaults(messages, PushMessage{
+		To:             []string{"ExponentPushToken[b]"},
+		Badge:          Int(3),
+		TTLSeconds:     Int(60),
+		MutableContent: true,
+	})
+	m := messages[0]
+	if len(m.To) != 1 || m.To[0] != "ExponentPushToken[a]" {
+		t.Error("Overwrote recipients")
+	}
+	if *m.Badge != 0 || *m.TTLSeconds != 0 || m.MutableContent {
+		t.Error("Overwrote a field explicitly set to zero")
+	}
+	if *messages[1].Badge != 3 || *messages[1].TTLSeconds != 60 {
+		t.Error("Didn't fill unset pointer fields")
+	}
+}
+
+func mixedResponses() []PushResponse {
+	return []PushResponse{
+		{Status: SuccessStatus},
+		{Status: "error", Details: map[string]json.RawMessage{"error": []byte(ErrorDeviceNotRegistered)}},
+		{Status: SuccessStatus},
+	}
+}
+
+func TestPartitionResponsesDefault(t *testing.T) {
+	p := PartitionResponses(mixedResponses(), PartitionOptions{})
+	if len(p.OK) != 2 || len(p.Failed) != 1 || len(p.Prunable) != 0 {
+		t.Errorf("Unexpected partition sizes %d/%d/%d", len(p.OK), len(p.Failed), len(p.Prunable))
+	}
+	if AllOK(mixedResponses(), PartitionOptions{}) {
+		t.Error("Expected unregistered device to count as a failure")
+	}
+}
+
+func TestPartitionResponsesPruneUnregistered(t *testing.T) {
+	opts := PartitionOptions{PruneUnregistered: true}
+	p := PartitionResponses(mixedResponses(), opts)
+	if len(p.OK) != 2 || len(p.Failed) != 0 || len(p.Prunable) != 1 {
+		t.Errorf("Unexpected partition sizes %d/%d/%d", len(p.OK), len(p.Failed), len(p.Prunable))
+	}
+	if !AllOK(mixedResponses(), opts) {
+		t.Error("Expected unregistered device not to count as a failure")
+	}
+	responses := append(mixedResponses(), PushResponse{Status: "error"})
+	if AllOK(responses, opts) {
+		t.Error("Expected a generic error to count as a failure")
+	}
+}
+
+func TestWriteUnregisteredTokens(t *testing.T) {
+	unregistered := map[string]json.RawMessage{"error": []byte(`"` + ErrorDeviceNotRegistered + `"`)}
+	responses := []PushResponse{
+		{PushMessage: PushMessage{To: []string{"ExponentPushToken[a]"}}, Status: "error", Details: unregistered},
+		{PushMessage: PushMessage{To: []string{"ExponentPushToken[b]"}}, Status: SuccessStatus},
+		{PushMessage: PushMessage{To: []string{"ExponentPushToken[c]"}}, Status: "error", Details: unregistered},
+		{PushMessage: PushMessage{To: []string{"ExponentPushToken[d]"}}, Status: "error"},
+		{PushMessage: PushMessage{To: []string{"ExponentPushToken[a]"}}, Status: "error", Details: unregistered},
+	}
+	var out strings.Builder
+	if err := WriteUnregisteredTokens(&out, responses); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ExponentPushToken[a]\nExponentPushToken[c]\n"; out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
+
+func TestSplitByPriority(t *testing.T) {
+	messages := []PushMessage{
+		{Body: "a", Priority: HighPriority},
+		{Body: "b", Priority: NormalPriority},
+		{Body: "c"},
+		{Body: "d", Priority: DefaultPriority},
+		{Body: "e", Priority: HighPriority},
+	}
+	groups := SplitByPriority(messages)
+	expected := map[string][]string{
+		HighPriority:    {"a", "e"},
+		NormalPriority:  {"b"},
+		DefaultPriority: {"d"},
+		"":              {"c"},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("Expected %d groups, got %d", len(expected), len(groups))
+	}
+	for priority, bodies := range expected {
+		group := groups[priority]
+		if len(group) != len(bodies) {
+			t.Fatalf("Expected %d messages with priority %q, got %d", len(bodies), priority, len(group))
+		}
+		for i, body := range bodies {
+			if group[i].Body != body {
+				t.Errorf("Unexpected message order for priority %q", priority)
+			}
+		}
+	}
+}
+
+func TestNormalizeRecipients(t *testing.T) {
+	to := make([]string, 5*MaxRecipientsPerMessage+1)
+	for i := range to {
+		to[i] = fmt.Sprintf("ExponentPushToken[%d]", i)
+	}
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[first]"}, Body: "first"},
+		{To: to, Body: "broadcast", Sound: "default"},
+	}
+	normalized := NormalizeRecipients(messages)
+	if len(normalized) != 7 {
+		t.Fatalf("Expected 7 messages, got %d", len(normalized))
+	}
+	var tokens []string
+	for i, m := range normalized[1:] {
+		if len(m.To) > MaxRecipientsPerMessage {
+			t.Errorf("Message %d has %d recipients", i, len(m.To))
+		}
+		if m.Body != "broadcast" || m.Sound != "default" {
+			t.Error("Didn't preserve message fields")
+		}
+		tokens = append(tokens, m.To...)
+	}
+	if !reflect.DeepEqual(tokens, to) {
+		t.Error("Didn't preserve the recipient order")
+	}
+	if len(NormalizeRecipients(messages[:1])) != 1 {
+		t.Error("Changed a message within the cap")
+	}
+}
+
+func TestPersonalizedMessages(t *testing.T) {
+	template := PushMessage{
+		Title: "Your order",
+		Sound: "default",
+		Data:  map[string]interface{}{"type": "order", "orderId": "template"},
+	}
+	messages, err := PersonalizedMessages(map[string]map[string]interface{}{
+		"ExponentPushToken[b]": {"orderId": 2},
+		"ExponentPushToken[a]": {"orderId": 1, "express": true},
+	}, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(messages))
+	}
+	a, b := messages[0], messages[1]
+	if a.To[0] != "ExponentPushToken[a]" || b.To[0] != "ExponentPushToken[b]" {
+		t.Error("Expected one message per token, ordered by token")
+	}
+	if a.Data["orderId"] != 1 || a.Data["express"] != true || b.Data["orderId"] != 2 {
+		t.Error("Didn't merge per-token data")
+	}
+	if a.Data["type"] != "order" || b.Title != "Your order" || b.Sound != "default" {
+		t.Error("Didn't keep template fields")
+	}
+	if template.Data["orderId"] != "template" {
+		t.Error("Modified the template")
+	}
+}
+
+func TestPersonalizedMessagesInvalidToken(t *testing.T) {
+	messages, err := PersonalizedMessages(map[string]map[string]interface{}{
+		"ExponentPushToken[a]": {"n": 1},
+		"not-a-token":          {"n": 2},
+	}, PushMessage{})
+	if !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("Expected a malformed token error, got %v", err)
+	}
+	if len(messages) != 1 || messages[0].To[0] != "ExponentPushToken[a]" {
+		t.Error("Expected messages for the valid tokens only")
+	}
+}
+
+func TestFingerprintExcludingTo(t *testing.T) {
+	a := PushMessage{
+		To:       []string{"ExponentPushToken[a]"},
+		Title:    "Sale",
+		Body:     "50% off",
+		Data:     map[string]interface{}{"x": 1, "y": "two", "z": true},
+		Metadata: map[string]string{"user": "a"},
+	}
+	b := a
+	b.To = []string{"ExponentPushToken[b]", "ExponentPushToken[c]"}
+	b.Data = map[string]interface{}{"z": true, "y": "two", "x": 1}
+	b.Metadata = map[string]string{"user": "b"}
+	if a.FingerprintExcludingTo() != b.FingerprintExcludingTo() {
+		t.Error("Expected messages differing only in recipients to share a fingerprint")
+	}
+
+	c := a
+	c.Body = "60% off"
+	if a.FingerprintExcludingTo() == c.FingerprintExcludingTo() {
+		t.Error("Expected messages with different content to have different fingerprints")
+	}
+}
+
+func TestGroupIdentical(t *testing.T) {
+	var messages []PushMessage
+	for i := 0; i < MaxRecipientsPerMessage+10; i++ {
+		messages = append(messages, PushMessage{To: []string{fmt.Sprintf("ExponentPushToken[sale-%d]", i)}, Body: "Sale"})
+	}
+	messages = append(messages,
+		PushMessage{To: []string{"ExponentPushToken[news-a]"}, Body: "News", Metadata: map[string]string{"campaign": "a"}},
+		PushMessage{To: []string{"ExponentPushToken[news-b]"}, Body: "News", Metadata: map[string]string{"campaign": "b"}},
+	)
+
+	grouped := GroupIdentical(messages)
+	if len(grouped) != 4 {
+		t.Fatalf("Expected 4 messages, got %d", len(grouped))
+	}
+	if len(grouped[0].To) != MaxRecipientsPerMessage || grouped[0].Body != "Sale" {
+		t.Errorf("Expected a full first group, got %d recipients", len(grouped[0].To))
+	}
+	if len(grouped[1].To) != 10 || grouped[1].Body != "Sale" {
+		t.Errorf("Expected the overflow in the second group, got %d recipients", len(grouped[1].To))
+	}
+	if grouped[2].Metadata["campaign"] != "a" || grouped[3].Metadata["campaign"] != "b" {
+		t.Error("Expected messages with different metadata to stay separate")
+	}
+	if messages[0].To[0] != "ExponentPushToken[sale-0]" || len(messages[0].To) != 1 {
+		t.Error("Modified the input messages")
+	}
+}
+
+func TestGroupIdenticalResponseAttribution(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}, Body: "x", Metadata: map[string]string{"n": "1"}},
+		{To: []string{"ExponentPushToken[b]"}, Body: "y"},
+		{To: []string{"ExponentPushToken[c]"}, Body: "x", Metadata: map[string]string{"n": "1"}},
+	}
+	grouped := GroupIdentical(messages)
+	if len(grouped) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(grouped))
+	}
+	responses, err := client.PublishMultiple(context.Background(), grouped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ token, body, n string }{
+		{"ExponentPushToken[a]", "x", "1"},
+		{"ExponentPushToken[c]", "x", "1"},
+		{"ExponentPushToken[b]", "y", ""},
+	}
+	for i, e := range expected {
+		r := responses[i]
+		if r.PushMessage.To[0] != e.token || r.PushMessage.Body != e.body || r.Metadata["n"] != e.n {
+			t.Errorf("Response %d isn't attributed to %s", i, e.token)
+		}
+	}
+}
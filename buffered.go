@@ -0,0 +1,179 @@
+package expo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultFlushInterval is the default delay between flushes of a BufferedClient
+const DefaultFlushInterval = time.Second
+
+// ErrBufferFull is returned by BufferedClient.Add when MaxBuffered messages are waiting
+var ErrBufferFull = errors.New("Buffered client is full")
+
+// ErrBufferedClientClosed is returned by BufferedClient.Add after Close
+var ErrBufferedClientClosed = errors.New("Buffered client is closed")
+
+// BufferedConfig controls when a BufferedClient flushes
+type BufferedConfig struct {
+	// FlushInterval is the delay between flushes, DefaultFlushInterval if zero
+	FlushInterval time.Duration
+	// MaxBuffered, if set, makes Add fail with ErrBufferFull once this many
+	// messages are waiting, e.g. while the client is paused. Zero means no limit.
+	MaxBuffered int
+	// OnFlush, if set, is called with the result of each flush's PublishMultiple call
+	OnFlush func(responses []PushResponse, err error)
+}
+
+// BufferedClient collects messages added from many goroutines and publishes
+// them together, every FlushInterval or as soon as a full chunk is waiting.
+type BufferedClient struct {
+	client *PushClient
+	config BufferedConfig
+
+	mu      sync.Mutex
+	buffer  []PushMessage
+	paused  bool
+	closed  bool
+	flushMu sync.Mutex // Serializes flushes so that messages are sent in order
+
+	flushNow chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	ctx      context.Context // Context of the periodic flushes, cancelled by Close
+	cancel   context.CancelFunc
+}
+
+// NewBufferedClient starts a BufferedClient publishing through client.
+// A nil config uses the defaults. Call Close to stop it.
+func NewBufferedClient(client *PushClient, config *BufferedConfig) *BufferedClient {
+	b := &BufferedClient{
+		client:   client,
+		flushNow: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	if config != nil {
+		b.config = *config
+	}
+	if b.config.FlushInterval <= 0 {
+		b.config.FlushInterval = DefaultFlushInterval
+	}
+	go b.run()
+	return b
+}
+
+// Add buffers message for the next flush
+func (b *BufferedClient) Add(message PushMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBufferedClientClosed
+	}
+	if b.config.MaxBuffered > 0 && len(b.buffer) >= b.config.MaxBuffered {
+		return ErrBufferFull
+	}
+	b.buffer = append(b.buffer, message)
+	if !b.paused && countNotifications(b.buffer) >= b.client.chunkSize {
+		b.signal()
+	}
+	return nil
+}
+
+// Pause stops the periodic and full-chunk flushes, e.g. during a maintenance
+// window, without dropping anything: Add keeps buffering, up to MaxBuffered if
+// set, so under load the buffer grows for as long as the client is paused.
+// A flush already in progress completes. Flush and Close still send.
+func (b *BufferedClient) Pause() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = true
+}
+
+// Resume restarts flushing after Pause, sending whatever was buffered
+// meanwhile without waiting for the next interval
+func (b *BufferedClient) Resume() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = false
+	if len(b.buffer) > 0 {
+		b.signal()
+	}
+}
+
+// Flush publishes the buffered messages now, even if the client is paused,
+// and returns the result of the PublishMultiple call
+func (b *BufferedClient) Flush(ctx context.Context) ([]PushResponse, error) {
+	return b.flush(ctx)
+}
+
+// Close stops the client, waiting for a periodic flush in progress, and then
+// flushes the buffered messages, even if the client is paused. If ctx is done
+// first, the periodic flush is cancelled, the messages still buffered are not
+// sent and ctx's error is returned. Add fails with ErrBufferedClientClosed afterwards.
+func (b *BufferedClient) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+	close(b.done)
+	defer b.cancel()
+	select {
+	case <-b.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	_, err := b.flush(ctx)
+	return err
+}
+
+// signal requests a flush without blocking; the caller holds mu
+func (b *BufferedClient) signal() {
+	select {
+	case b.flushNow <- struct{}{}:
+	default:
+	}
+}
+
+// run flushes every interval or when signalled, unless paused, until Close
+func (b *BufferedClient) run() {
+	defer close(b.stopped)
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.client.clock.After(b.config.FlushInterval):
+		case <-b.flushNow:
+		}
+		b.mu.Lock()
+		paused := b.paused
+		b.mu.Unlock()
+		if !paused {
+			b.flush(b.ctx)
+		}
+	}
+}
+
+// flush publishes and clears the buffer, reporting the result to OnFlush
+func (b *BufferedClient) flush(ctx context.Context) ([]PushResponse, error) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	messages := b.buffer
+	b.buffer = nil
+	b.mu.Unlock()
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	responses, err := b.client.PublishMultiple(ctx, messages)
+	if b.config.OnFlush != nil {
+		b.config.OnFlush(responses, err)
+	}
+	return responses, err
+}
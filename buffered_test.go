@@ -0,0 +1,126 @@
+package expo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBufferedClientPauseResume(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	var mu sync.Mutex
+	flushed := 0
+	buffered := NewBufferedClient(NewPushClient(&ClientConfig{Host: server.URL}), &BufferedConfig{
+		FlushInterval: 10 * time.Millisecond,
+		OnFlush: func(responses []PushResponse, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			flushed += len(responses)
+		},
+	})
+
+	buffered.Pause()
+	for _, m := range testMessages(3) {
+		if err := buffered.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Fatalf("Expected no flush while paused, got %d requests", n)
+	}
+
+	buffered.Resume()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return flushed
+	}
+	deadline := time.Now().Add(time.Second)
+	for count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("Expected the messages buffered while paused to be flushed, got %d", n)
+	}
+
+	buffered.Pause()
+	for _, m := range testMessages(2) {
+		buffered.Add(m)
+	}
+	if err := buffered.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 5 {
+		t.Errorf("Expected Close to flush while paused, got %d flushed", n)
+	}
+	if err := buffered.Add(testMessages(1)[0]); !errors.Is(err, ErrBufferedClientClosed) {
+		t.Errorf("Expected Add to fail after Close, got %v", err)
+	}
+}
+
+func TestBufferedClientMaxBuffered(t *testing.T) {
+	buffered := NewBufferedClient(NewPushClient(nil), &BufferedConfig{MaxBuffered: 2})
+	buffered.Pause()
+	messages := testMessages(3)
+	if buffered.Add(messages[0]) != nil || buffered.Add(messages[1]) != nil {
+		t.Fatal("Expected messages within the limit to be buffered")
+	}
+	if err := buffered.Add(messages[2]); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("Expected ErrBufferFull, got %v", err)
+	}
+	buffered.mu.Lock()
+	buffered.buffer = nil
+	buffered.mu.Unlock()
+	buffered.Close(context.Background())
+}
+
+func TestBufferedClientCloseDeadline(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		// Hang until the client gives up or the test ends
+		select {
+		case <-req.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	buffered := NewBufferedClient(NewPushClient(&ClientConfig{Host: server.URL}), &BufferedConfig{FlushInterval: 10 * time.Millisecond})
+	buffered.Add(testMessages(1)[0])
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	closed := make(chan error, 1)
+	go func() { closed <- buffered.Close(ctx) }()
+	select {
+	case err := <-closed:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the deadline to be exceeded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to return at its deadline despite a hung flush")
+	}
+	// The hung periodic flush is cancelled too
+	select {
+	case <-buffered.stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected Close to cancel the periodic flush")
+	}
+}
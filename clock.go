@@ -0,0 +1,21 @@
+package expo
+
+import "time"
+
+// Clock is the source of time used by the client for waits and timestamps.
+// It can be replaced via ClientConfig.Clock, typically in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the Clock backed by the time package
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,71 @@
+package expo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+)
+
+// pushTokenPattern matches Expo push tokens so they can be redacted
+var pushTokenPattern = regexp.MustCompile(`Expo(nent)?PushToken\[[^\]]*\]`)
+
+// redactTokens replaces every push token in b with a placeholder
+func redactTokens(b []byte) []byte {
+	return pushTokenPattern.ReplaceAll(b, []byte("ExponentPushToken[REDACTED]"))
+}
+
+// logFailedBody logs the redacted body of a failed chunk, if LogFailedBodies is set
+func (c *PushClient) logFailedBody(b *batch, index int, chunk []PushMessage) {
+	if !c.logFailedBodies || c.logger == nil {
+		return
+	}
+	body, err := c.marshalChunk(chunk)
+	if err != nil {
+		c.logf("batch %s: chunk %d body: %v", b.id, index, err)
+		return
+	}
+	c.logf("batch %s: chunk %d body: %s", b.id, index, redactTokens(body))
+}
+
+// debugRequest echoes req to the debug writer, if one is configured
+func (c *PushClient) debugRequest(req *http.Request) {
+	if c.debug == nil {
+		return
+	}
+	var body []byte
+	if req.GetBody != nil {
+		if r, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(r)
+		}
+	}
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		if zr, err := gzip.NewReader(bytes.NewReader(body)); err == nil {
+			body, _ = io.ReadAll(zr)
+		}
+	}
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
+	fmt.Fprintf(c.debug, "> %s %s\n", req.Method, req.URL)
+	if req.Header.Get("Authorization") != "" {
+		fmt.Fprintf(c.debug, "> Authorization: [REDACTED]\n")
+	}
+	fmt.Fprintf(c.debug, "> %s\n", redactTokens(body))
+}
+
+// debugResponse echoes a response and its decoded body to the debug writer, if one is configured
+func (c *PushClient) debugResponse(resp *http.Response, body []byte, err error) {
+	if c.debug == nil {
+		return
+	}
+	c.outputMu.Lock()
+	defer c.outputMu.Unlock()
+	if err != nil {
+		fmt.Fprintf(c.debug, "< error: %s\n", err)
+		return
+	}
+	fmt.Fprintf(c.debug, "< %s\n", resp.Status)
+	fmt.Fprintf(c.debug, "< %s\n", redactTokens(body))
+}
@@ -1,3 +1,5 @@
 module github.com/stillmatic/exponent-server-sdk-golang
 
-go 1.18
+go 1.20
+
+require golang.org/x/time v0.5.0
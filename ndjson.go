@@ -0,0 +1,67 @@
+package expo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxNDJSONLineSize is the longest line ValidateNDJSON accepts
+const maxNDJSONLineSize = 1 << 20
+
+// ValidateNDJSON reads newline-delimited JSON PushMessage objects from r, such
+// as a campaign file, and checks each as ValidateAll does. It returns the
+// number of valid messages and an error for each problem, prefixed with its
+// line number counting from one. Validation problems wrap a *ValidationError
+// whose Index is the line number. Blank lines, including whitespace-only
+// ones, are skipped.
+func ValidateNDJSON(r io.Reader) (valid int, errs []error) {
+	client := NewPushClient(nil)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var message PushMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		messageErrs := client.messageErrors(line, message)
+		for _, err := range messageErrs {
+			errs = append(errs, &lineError{line: line, err: err})
+		}
+		if len(messageErrs) == 0 {
+			valid++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("line %d: %w", line+1, err))
+	}
+	return valid, errs
+}
+
+// lineError is a validation problem on a line of NDJSON, named by the line
+// rather than as a message of a batch
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	var validation *ValidationError
+	if errors.As(e.err, &validation) {
+		return fmt.Sprintf("line %d: %s %s", e.line, validation.Field, validation.Err)
+	}
+	return fmt.Sprintf("line %d: %s", e.line, e.err)
+}
+
+func (e *lineError) Unwrap() error {
+	return e.err
+}
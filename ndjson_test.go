@@ -0,0 +1,37 @@
+package expo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateNDJSON(t *testing.T) {
+	campaign := strings.Join([]string{
+		`{"to": ["ExponentPushToken[a]"], "body": "hi"}`,
+		`{"to": ["ExponentPushToken[b]"], "body": `,
+		``,
+		`{"to": ["not-a-token"], "body": "hi"}`,
+		`{"to": ["ExponentPushToken[c]"], "title": "hello"}`,
+		" \t",
+		"\r",
+	}, "\n")
+
+	valid, errs := ValidateNDJSON(strings.NewReader(campaign))
+	if valid != 2 {
+		t.Errorf("Expected 2 valid messages, got %d", valid)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", errs)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "line 2: ") {
+		t.Errorf("Expected the malformed line's number, got %v", errs[0])
+	}
+	if !strings.HasPrefix(errs[1].Error(), "line 4: To[0] ") || !errors.Is(errs[1], ErrMalformedToken) {
+		t.Errorf("Expected the invalid token's line and error, got %v", errs[1])
+	}
+	var validation *ValidationError
+	if !errors.As(errs[1], &validation) || validation.Index != 4 || strings.Contains(errs[1].Error(), "message") {
+		t.Errorf("Expected a validation error for line 4 without a message index, got %v", errs[1])
+	}
+}
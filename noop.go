@@ -0,0 +1,84 @@
+package expo
+
+import (
+	"context"
+	"fmt"
+)
+
+var _ Publisher = (*NoopClient)(nil)
+
+// NoopClient is a Publisher which validates messages like a PushClient but
+// sends nothing, answering each notification with a synthetic ok response,
+// e.g. to disable sends behind a feature flag or in tests.
+type NoopClient struct {
+	client *PushClient
+}
+
+// NewNoopClient creates a NoopClient validating and preparing messages as a
+// PushClient with config would. Options which affect only sending are ignored.
+func NewNoopClient(config *ClientConfig) *NoopClient {
+	return &NoopClient{client: NewPushClient(config)}
+}
+
+// Publish validates a single message and returns a synthetic ok response per recipient
+func (n *NoopClient) Publish(ctx context.Context, message *PushMessage, opts ...PublishOption) ([]PushResponse, error) {
+	return n.PublishMultiple(ctx, []PushMessage{*message}, opts...)
+}
+
+// PublishMultiple validates messages and returns a synthetic ok response per
+// recipient, in order, with a unique receipt ID and the fields a PushClient
+// would set, without any network calls
+func (n *NoopClient) PublishMultiple(ctx context.Context, messages []PushMessage, opts ...PublishOption) ([]PushResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c := n.client
+	messages = c.prepare(messages)
+	if _, err := c.validate(messages); err != nil {
+		return nil, err
+	}
+	messages = c.transform(messages)
+
+	var options publishOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.batchID == "" {
+		options.batchID = newBatchID()
+	}
+	sentAt := c.clock.Now()
+	responses := make([]PushResponse, 0, countNotifications(messages))
+	for i, chunk := range c.chunk(messages) {
+		for _, msg := range chunk {
+			for _, to := range msg.To {
+				r := PushResponse{
+					PushMessage: msg,
+					ID:          fmt.Sprintf("noop-%s-%d", options.batchID, len(responses)),
+					Status:      SuccessStatus,
+					Metadata:    msg.Metadata,
+					SentAt:      sentAt,
+					BatchID:     options.batchID,
+					ChunkIndex:  i,
+				}
+				r.PushMessage.To = []string{to}
+				responses = append(responses, r)
+			}
+		}
+	}
+	return responses, nil
+}
+
+// GetPushNotificationReceipts returns an ok receipt for each non-empty ID,
+// as if every notification had been delivered
+func (n *NoopClient) GetPushNotificationReceipts(ctx context.Context, ids []string) (map[string]PushReceipt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	receipts := make(map[string]PushReceipt, len(ids))
+	for _, id := range ids {
+		if id != "" {
+			receipts[id] = PushReceipt{Status: SuccessStatus}
+		}
+	}
+	return receipts, nil
+}
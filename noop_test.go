@@ -0,0 +1,50 @@
+package expo
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNoopClient(t *testing.T) {
+	var publisher Publisher = NewNoopClient(&ClientConfig{Host: "http://127.0.0.1:1"})
+	messages := testMessages(MaxNotificationsPerRequest + 1)
+	messages[0].Metadata = map[string]string{"user": "42"}
+	responses, err := publisher.PublishMultiple(context.Background(), messages, WithBatchID("flagged"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != len(messages) {
+		t.Fatalf("Expected a response per notification, got %d", len(responses))
+	}
+	ids := map[string]bool{}
+	for i, r := range responses {
+		if err := r.ValidateResponse(); err != nil {
+			t.Errorf("Response %d: expected ok, got %v", i, err)
+		}
+		if r.ID == "" || ids[r.ID] {
+			t.Errorf("Response %d: expected a unique receipt ID, got %q", i, r.ID)
+		}
+		ids[r.ID] = true
+		if r.PushMessage.To[0] != messages[i].To[0] || r.BatchID != "flagged" || r.SentAt.IsZero() {
+			t.Errorf("Response %d: expected the message, batch ID and send time, got %+v", i, r)
+		}
+	}
+	if responses[0].Metadata["user"] != "42" || responses[MaxNotificationsPerRequest].ChunkIndex != 1 {
+		t.Error("Expected the metadata and chunk index of a real send")
+	}
+
+	if _, err := publisher.Publish(context.Background(), &PushMessage{To: []string{"not-a-token"}}); err == nil {
+		t.Error("Expected the noop client to validate messages")
+	}
+	receipts, err := publisher.GetPushNotificationReceipts(context.Background(), []string{responses[0].ID, ""})
+	receipt := receipts[responses[0].ID]
+	if err != nil || len(receipts) != 1 || receipt.ValidateReceipt() != nil {
+		t.Errorf("Expected an ok receipt for the ID, got %v and %v", receipts, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := publisher.PublishMultiple(ctx, messages); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a canceled context to be honored, got %v", err)
+	}
+}
@@ -1,23 +1,54 @@
 package expo
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // ErrMalformedToken is returned if a token does not start with 'ExponentPushToken'
 var ErrMalformedToken = errors.New("Token should start with ExponentPushToken")
 
-// NewExponentPushToken returns a token and may return an error if the input token is invalid
+// ErrMissingAccessToken is returned, with ClientConfig.RequireAccessToken,
+// when the client has no access token to send
+var ErrMissingAccessToken = errors.New("Access token is required but not set")
+
+// ErrTokenWhitespace is returned if a token contains whitespace other than
+// leading or trailing whitespace, which is trimmed
+var ErrTokenWhitespace = errors.New("Token should not contain whitespace")
+
+// ErrInvalidDefaultPriority is returned by ClientConfig.Validate if
+// DefaultPriority is not DefaultPriority, NormalPriority or HighPriority
+var ErrInvalidDefaultPriority = errors.New("Default priority should be default, normal or high")
+
+// NewExponentPushToken returns a token and may return an error if the input token is invalid.
+// Leading and trailing whitespace, such as a newline left from copying a token out
+// of a log, is trimmed from the returned token; whitespace within it is an error.
 func NewExponentPushToken(token string) (string, error) {
-	if !strings.HasPrefix(token, "ExponentPushToken") {
-		return "", ErrMalformedToken
+	token = strings.TrimSpace(token)
+	if err := tokenError(token); err != nil {
+		return "", err
 	}
 	return token, nil
 }
 
+// tokenError returns the problem with a trimmed token, if any
+func tokenError(token string) error {
+	if strings.IndexFunc(token, unicode.IsSpace) >= 0 {
+		return ErrTokenWhitespace
+	}
+	if !strings.HasPrefix(token, "ExponentPushToken") {
+		return ErrMalformedToken
+	}
+	return nil
+}
+
 const (
 	// DefaultPriority is the standard priority used in PushMessage
 	DefaultPriority = "default"
@@ -27,21 +58,182 @@ const (
 	HighPriority = "high"
 )
 
+// NormalizePriority lowercases and trims a priority and maps known synonyms,
+// such as the APNs priorities "10" and "5", to the priority constants.
+// Unrecognized priorities are returned lowercased for Expo to reject.
+func NormalizePriority(p string) string {
+	p = strings.ToLower(strings.TrimSpace(p))
+	switch p {
+	case "10", "urgent":
+		return HighPriority
+	case "5":
+		return NormalPriority
+	}
+	return p
+}
+
 // PushMessage is an object that describes a push notification request.
 // https://github.com/expo/expo/blob/f14ebb06b858e893ed569fd29b60be6146057c10/docs/pages/push-notifications/sending-notifications.mdx#message-request-format
 type PushMessage struct {
-	To             []string          `json:"to"`                       // An Expo push token or an array of Expo push tokens specifying the recipient(s) of this message.
-	Body           string            `json:"body"`                     // The message to display in the notification.
-	Data           map[string]string `json:"data,omitempty"`           // A JSON object delivered to your app.
-	Sound          string            `json:"sound,omitempty"`          // Play a sound when the recipient receives this notification.
-	Title          string            `json:"title,omitempty"`          // The title to display in the notification.
-	TTLSeconds     int               `json:"ttl,omitempty"`            // Time to Live: the number of seconds for which the message may be kept around for redelivery if it hasn't been delivered yet.
-	Expiration     int64             `json:"expiration,omitempty"`     // Timestamp since the Unix epoch specifying when the message expires.
-	Priority       string            `json:"priority,omitempty"`       // The delivery priority of the message.
-	Badge          int               `json:"badge,omitempty"`          // Number to display in the badge on the app icon.
-	ChannelID      string            `json:"channelId,omitempty"`      // ID of the Notification Channel through which to display this notification.
-	CategoryID     string            `json:"categoryId,omitempty"`     // ID of the notification category that this notification is associated with.
-	MutableContent bool              `json:"mutableContent,omitempty"` // Specifies whether this notification can be intercepted by the client app.
+	To             []string               `json:"to"`                       // An Expo push token or an array of Expo push tokens specifying the recipient(s) of this message.
+	Body           string                 `json:"body"`                     // The message to display in the notification.
+	Data           map[string]interface{} `json:"data,omitempty"`           // A JSON object delivered to your app.
+	Sound          string                 `json:"sound,omitempty"`          // Play a sound when the recipient receives this notification.
+	Title          string                 `json:"title,omitempty"`          // The title to display in the notification.
+	Subtitle       string                 `json:"subtitle,omitempty"`       // The subtitle to display in the notification below the title (iOS only).
+	TTLSeconds     *int                   `json:"ttl,omitempty"`            // Time to Live: the number of seconds for which the message may be kept around for redelivery if it hasn't been delivered yet. Zero is sent, nil is omitted.
+	Expiration     int64                  `json:"expiration,omitempty"`     // Timestamp since the Unix epoch specifying when the message expires. Zero is unset.
+	Priority       string                 `json:"priority,omitempty"`       // The delivery priority of the message.
+	Badge          *int                   `json:"badge,omitempty"`          // Number to display in the badge on the app icon. Zero clears the badge, nil leaves it unchanged.
+	ChannelID      string                 `json:"channelId,omitempty"`      // ID of the Notification Channel through which to display this notification.
+	CategoryID     string                 `json:"categoryId,omitempty"`     // ID of the notification category that this notification is associated with.
+	MutableContent bool                   `json:"mutableContent,omitempty"` // Specifies whether this notification can be intercepted by the client app.
+
+	// DisplayInForeground controls whether the notification is shown while the app is in the foreground.
+	// It is only honored by legacy Expo clients (SDK 38 and below); newer clients decide this
+	// in the app via Notifications.setNotificationHandler. Unset leaves the client default.
+	DisplayInForeground *bool `json:"_displayInForeground,omitempty"`
+
+	// ContentAvailable marks the notification as a silent, background push
+	// which wakes the app without displaying anything (iOS only).
+	ContentAvailable bool `json:"_contentAvailable,omitempty"`
+
+	// TargetContentID is forwarded to APNs as target-content-id, bringing the
+	// window with the matching identifier to the front when the notification is opened (iOS only).
+	TargetContentID string `json:"targetContentId,omitempty"`
+
+	// APNSCollapseID is forwarded to APNs as apns-collapse-id: a newer
+	// notification with the same ID replaces the displayed one (iOS only).
+	// It may be at most MaxAPNSCollapseIDBytes long.
+	APNSCollapseID string `json:"collapseId,omitempty"`
+
+	// AndroidCollapseKey is forwarded to FCM as collapse_key: while the device
+	// is offline only the last notification with the same key is kept for
+	// delivery (Android only). FCM keeps at most four keys per device at once.
+	AndroidCollapseKey string `json:"collapseKey,omitempty"`
+
+	// Metadata is caller data, such as a user or campaign ID, which is never sent to Expo.
+	// It is copied to each PushResponse produced by this message.
+	Metadata map[string]string `json:"-"`
+}
+
+const (
+	// APNSPushTypeAlert is the apns-push-type of notifications which display an alert, play a sound or badge the app
+	APNSPushTypeAlert = "alert"
+	// APNSPushTypeBackground is the apns-push-type of silent notifications which only wake the app
+	APNSPushTypeBackground = "background"
+)
+
+// APNSPushType returns the apns-push-type Expo infers for the message:
+// background for a ContentAvailable message with no title, subtitle, body,
+// sound or badge, and alert otherwise.
+func (m PushMessage) APNSPushType() string {
+	if m.ContentAvailable && !m.hasAlertContent() && m.Sound == "" && m.Badge == nil {
+		return APNSPushTypeBackground
+	}
+	return APNSPushTypeAlert
+}
+
+// hasAlertContent reports whether the message has text to display
+func (m PushMessage) hasAlertContent() bool {
+	return m.Title != "" || m.Subtitle != "" || m.Body != ""
+}
+
+// ErrNoRecipients is returned when a message has no recipients
+var ErrNoRecipients = errors.New("has no recipients")
+
+// ErrControlCharacter is returned in strict mode when a display field contains a control character
+var ErrControlCharacter = errors.New("contains a control character")
+
+// ErrExpired is returned in strict mode when a message's expiration has already passed
+var ErrExpired = errors.New("is in the past")
+
+// ErrNoDisplayText is returned, when display text is required, for a message
+// which is neither silent nor has a Title, Subtitle or Body
+var ErrNoDisplayText = errors.New("is empty for a non-silent notification")
+
+// ErrBackgroundAlert is returned in strict mode for a ContentAvailable message
+// with display text, a sound or a badge, which would be sent as an alert
+// rather than a background push
+var ErrBackgroundAlert = errors.New("is set on a message with alert content")
+
+// MaxTTLSeconds is the longest TTLSeconds the push services honour, 28 days.
+// FCM silently clamps longer TTLs to it.
+const MaxTTLSeconds = 28 * 24 * 60 * 60
+
+// TTLTooLongError is returned, with ClientConfig.EnforceMaxTTL, for a
+// message whose TTLSeconds exceeds the platform maximum
+type TTLTooLongError struct {
+	TTLSeconds int // TTL of the message
+	Max        int // Longest TTL allowed, MaxTTLSeconds
+}
+
+func (e *TTLTooLongError) Error() string {
+	return fmt.Sprintf("of %d exceeds the maximum of %d seconds", e.TTLSeconds, e.Max)
+}
+
+// UnknownCategoryError is returned in strict mode for a message whose
+// CategoryID isn't one of ClientConfig.KnownCategories
+type UnknownCategoryError struct {
+	CategoryID string
+}
+
+func (e *UnknownCategoryError) Error() string {
+	return fmt.Sprintf("%q is not a known category", e.CategoryID)
+}
+
+// MaxAPNSCollapseIDBytes is the longest apns-collapse-id APNs accepts
+const MaxAPNSCollapseIDBytes = 64
+
+// ErrCollapseIDTooLong is returned when a message's APNSCollapseID is longer
+// than MaxAPNSCollapseIDBytes, which APNs rejects
+var ErrCollapseIDTooLong = fmt.Errorf("is longer than %d bytes", MaxAPNSCollapseIDBytes)
+
+// ErrEmptyReceiptID is returned in strict mode when a receipt lookup includes an empty ID
+var ErrEmptyReceiptID = errors.New("is empty")
+
+// ReceiptIDError is returned when a receipt ID in a lookup fails validation
+type ReceiptIDError struct {
+	Index int // Index of the receipt ID in the lookup
+	Err   error
+}
+
+func (e *ReceiptIDError) Error() string {
+	return fmt.Sprintf("receipt ID %d %s", e.Index, e.Err)
+}
+
+func (e *ReceiptIDError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned when a message in a batch fails validation
+type ValidationError struct {
+	Index int    // Index of the message in the batch
+	Field string // Name of the PushMessage field which failed validation
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("message %d: %s %s", e.Index, e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Bool returns a pointer to v, for setting optional fields such as DisplayInForeground
+func Bool(v bool) *bool {
+	return &v
+}
+
+// Int returns a pointer to v, for setting optional fields such as TTLSeconds and Badge
+func Int(v int) *int {
+	return &v
+}
+
+// Int64 returns a pointer to v
+func Int64(v int64) *int64 {
+	return &v
 }
 
 // Response is the HTTP response returned from an Expo publish HTTP request
@@ -86,10 +278,48 @@ type PushResponse struct {
 	Status      string                     `json:"status"`
 	Message     string                     `json:"message"`
 	Details     map[string]json.RawMessage `json:"details"`
+	Metadata    map[string]string          `json:"-"` // Metadata of the message which produced this response
+	SentAt      time.Time                  `json:"-"` // When the client dispatched the request, by the client's clock
+	BatchID     string                     `json:"-"` // Batch ID of the PublishMultiple call, see WithBatchID
+	Timing      *RequestTiming             `json:"-"` // Timing of the request which sent the notification, with ClientConfig.TraceRequests
+	ChunkIndex  int                        `json:"-"` // Index of the chunk which sent the notification, matching the bodies of MarshalBatch
+
+	successStatuses []string                                       // Statuses counted as success, set by the client from ClientConfig.SuccessStatuses
+	classify        func(details map[string]json.RawMessage) error // Custom classifier, set by the client from ClientConfig.ClassifyError
+	raw             json.RawMessage                                // The decoded item, kept only if it had no status
+	accessToken     string                                         // Access token the notification was sent with, set by the client
+}
+
+// UnmarshalJSON decodes a response item, keeping the raw item if it has no
+// status so that ValidateResponse can report it in a *MissingStatusError
+func (r *PushResponse) UnmarshalJSON(data []byte) error {
+	type plain PushResponse
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*r = PushResponse(p)
+	if r.Status == "" {
+		r.raw = append(json.RawMessage(nil), data...)
+	}
+	return nil
 }
 
 func (r *PushResponse) isSuccess() bool {
-	return r.Status == SuccessStatus
+	return isSuccessStatus(r.Status, r.successStatuses)
+}
+
+// isSuccessStatus reports whether status is one of statuses, or SuccessStatus if statuses is empty
+func isSuccessStatus(status string, statuses []string) bool {
+	if len(statuses) == 0 {
+		return status == SuccessStatus
+	}
+	for _, s := range statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
 }
 
 // ValidateResponse returns an error if the response indicates that one occurred.
@@ -102,9 +332,21 @@ func (r *PushResponse) ValidateResponse() error {
 	err := &PushResponseError{
 		Response: r,
 	}
+	// A decoded item without a status suggests the response format has
+	// changed, rather than that the notification failed. Responses the client
+	// builds itself, such as for a failed chunk, have no raw item.
+	if r.Status == "" && r.raw != nil {
+		return &MissingStatusError{PushResponseError: *err, Raw: r.raw}
+	}
+	// Let a custom classifier handle codes it knows first
+	if r.classify != nil {
+		if custom := r.classify(r.Details); custom != nil {
+			return custom
+		}
+	}
 	// Handle specific errors if we have information
 	if r.Details != nil {
-		e := string(r.Details["error"])
+		e := r.detailsCode()
 		if e == ErrorDeviceNotRegistered {
 			return &DeviceNotRegisteredError{
 				PushResponseError: *err,
@@ -134,6 +376,37 @@ func (r *PushResponse) ValidateResponse() error {
 	return err
 }
 
+// detailsCodeKeys are the details keys providers carry an error code under, in order of precedence
+var detailsCodeKeys = []string{"error", "fault", "code"}
+
+// detailsCode returns the machine-readable error code from the details,
+// read from the first of detailsCodeKeys which is present
+func (r *PushResponse) detailsCode() string {
+	for _, key := range detailsCodeKeys {
+		if raw, ok := r.Details[key]; ok {
+			return detailsString(raw)
+		}
+	}
+	return ""
+}
+
+// detailsString decodes a details value, which Expo sends as a JSON string.
+// Values which aren't JSON strings are used as-is.
+func detailsString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// ShouldRemoveToken reports whether the recipient's token is no longer registered
+// and should be removed from storage.
+func (r PushResponse) ShouldRemoveToken() bool {
+	_, ok := r.ValidateResponse().(*DeviceNotRegisteredError)
+	return ok
+}
+
 // ProviderError is raised when the provider (FCM or APNs) respond error
 // On Android, error message is json string. for example: {"fcm":{"error":"MismatchSenderId"}}
 type ProviderError struct {
@@ -144,8 +417,12 @@ type MismatchSenderIdError struct {
 	PushResponseError
 }
 
+// InvalidCredentialsError is raised when push credentials are invalid, or when
+// Expo rejects a request with a 401. TokenProvided then distinguishes a
+// missing access token from a rejected one.
 type InvalidCredentialsError struct {
 	PushResponseError
+	TokenProvided bool
 }
 
 // PushResponseError is a base class for all push reponse errors
@@ -160,6 +437,45 @@ func (e *PushResponseError) Error() string {
 	return "Unknown push response error"
 }
 
+// Code returns the machine-readable error code from the response details,
+// read from "code" or, if that is absent, "error" then "fault"
+func (e *PushResponseError) Code() string {
+	if e.Response == nil {
+		return ""
+	}
+	if raw, ok := e.Response.Details["code"]; ok {
+		return detailsString(raw)
+	}
+	return e.Response.detailsCode()
+}
+
+// Message returns the human readable error message, read from the "message"
+// detail or, if that is absent, the response's Message
+func (e *PushResponseError) Message() string {
+	if e.Response == nil {
+		return ""
+	}
+	if raw, ok := e.Response.Details["message"]; ok {
+		return detailsString(raw)
+	}
+	return e.Response.Message
+}
+
+// MissingStatusError is raised for a decoded response item with no status at
+// all, which points to a change in Expo's response format rather than a
+// failed notification. Raw holds the item as received.
+type MissingStatusError struct {
+	PushResponseError
+	Raw json.RawMessage
+}
+
+func (e *MissingStatusError) Error() string {
+	if len(e.Raw) == 0 {
+		return "Push response has no status, the response format may have changed"
+	}
+	return fmt.Sprintf("Push response has no status, the response format may have changed: %s", e.Raw)
+}
+
 // DeviceNotRegisteredError is raised when the push token is invalid
 // To handle this error, you should stop sending messages to this token.
 type DeviceNotRegisteredError struct {
@@ -178,6 +494,42 @@ type MessageRateExceededError struct {
 	PushResponseError
 }
 
+// RetryAfter returns the delay Expo suggested before retrying, read from the
+// retryAfter (seconds) or expires (Unix timestamp) details, or zero if absent
+func (e *MessageRateExceededError) RetryAfter() time.Duration {
+	return e.retryAfterAt(time.Now())
+}
+
+// retryAfterAt is RetryAfter measuring the expires hint from now, so that the
+// client can use its own clock
+func (e *MessageRateExceededError) retryAfterAt(now time.Time) time.Duration {
+	if e.Response == nil || e.Response.Details == nil {
+		return 0
+	}
+	if seconds, ok := detailsNumber(e.Response.Details["retryAfter"]); ok && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if expires, ok := detailsNumber(e.Response.Details["expires"]); ok {
+		if d := time.Unix(int64(expires), 0).Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// detailsNumber decodes a details value sent as a JSON number or numeric string
+func detailsNumber(raw json.RawMessage) (float64, bool) {
+	if raw == nil {
+		return 0, false
+	}
+	var n float64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n, true
+	}
+	n, err := strconv.ParseFloat(detailsString(raw), 64)
+	return n, err == nil
+}
+
 // PushServerError is raised when the push token server is not behaving as expected
 // For example, invalid push notification arguments result in a different
 // style of error. Instead of a "data" array containing errors per
@@ -211,3 +563,113 @@ func NewPushServerError(message string, response *http.Response,
 func (e *PushServerError) Error() string {
 	return e.Message
 }
+
+// HTTPStatusError is returned when Expo responds with a non-2xx HTTP status
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration // Parsed from the Retry-After header, zero if absent
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("Invalid response (%d %s)", e.StatusCode, e.Status)
+}
+
+// ResponseLengthMismatchError is returned when Expo's data array doesn't
+// hold one response per notification. If it is short, the responses which
+// were received are still returned, mapped to the leading notifications.
+type ResponseLengthMismatchError struct {
+	Expected int            // Notifications in the request
+	Received int            // Entries in the data array
+	Data     []PushResponse // The data array as received
+}
+
+func (e *ResponseLengthMismatchError) Error() string {
+	return fmt.Sprintf("Mismatched response length. Expected %d receipts but only received %d", e.Expected, e.Received)
+}
+
+// PayloadTooLargeError is returned when Expo rejects a request body as too
+// large with a 413. Reduce ClientConfig.ChunkSize, or set
+// ClientConfig.MaxChunkBytes to have the client split such chunks itself.
+type PayloadTooLargeError struct {
+	Size int64 // Size of the rejected request body in bytes
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("Payload too large (%d bytes), reduce the chunk size", e.Size)
+}
+
+// ChunkError is returned when one chunk request of a batch fails.
+// Messages holds the chunk's notifications which got no response, so that
+// they can be persisted and replayed, see SerializeBatch; those salvaged from
+// a short response are left out, as they were already sent.
+type ChunkError struct {
+	Index    int // Index of the chunk in the batch
+	Messages []PushMessage
+	Err      error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %d: %s", e.Index, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// ContextError is returned when a chunk request stops because its context
+// was cancelled or passed its deadline. It unwraps to context.Canceled or
+// context.DeadlineExceeded, so errors.Is still works.
+type ContextError struct {
+	Chunk   int // Index of the chunk in the batch
+	Attempt int // Attempt the chunk was on, counting from one
+	Err     error
+}
+
+func (e *ContextError) Error() string {
+	return fmt.Sprintf("chunk %d attempt %d: %s", e.Chunk, e.Attempt, e.Err)
+}
+
+func (e *ContextError) Unwrap() error {
+	return e.Err
+}
+
+// Canceled reports whether the context was cancelled rather than timed out
+func (e *ContextError) Canceled() bool {
+	return errors.Is(e.Err, context.Canceled)
+}
+
+// DeadlineExceeded reports whether the context passed its deadline
+func (e *ContextError) DeadlineExceeded() bool {
+	return errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// MalformedResponseError is returned when a response body can't be decoded
+// according to its Content-Encoding, e.g. a body labeled gzip which isn't
+type MalformedResponseError struct {
+	Encoding string // The declared Content-Encoding, empty if none
+	Snippet  string // The start of the raw body
+	Err      error
+}
+
+// maxSnippetLength is the number of raw body bytes kept in a MalformedResponseError
+const maxSnippetLength = 256
+
+func newMalformedResponseError(encoding string, raw []byte, err error) *MalformedResponseError {
+	if len(raw) > maxSnippetLength {
+		raw = raw[:maxSnippetLength]
+	}
+	return &MalformedResponseError{Encoding: encoding, Snippet: string(raw), Err: err}
+}
+
+func (e *MalformedResponseError) Error() string {
+	encoding := e.Encoding
+	if encoding == "" {
+		encoding = "identity"
+	}
+	return fmt.Sprintf("Malformed response with %s encoding: %s: %q", encoding, e.Err, e.Snippet)
+}
+
+func (e *MalformedResponseError) Unwrap() error {
+	return e.Err
+}
@@ -2,12 +2,25 @@ package expo
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -17,14 +30,86 @@ const (
 	DefaultBaseAPIURL = "/--/api/v2"
 )
 
+// NotificationsPerSecond is Expo's documented limit on notifications sent per second per project
+const NotificationsPerSecond = 600
+
+// MaxNotificationsPerRequest is the number of notifications Expo accepts in a single push request.
+// Callers doing their own batching can size their batches with it.
+const MaxNotificationsPerRequest = 100
+
 // DefaultHTTPClient is the default *http.Client for making API requests
 var DefaultHTTPClient = &http.Client{}
 
+// SharedLimiter returns a limiter sized to Expo's documented send rate.
+// Pass the same limiter to several clients via ClientConfig.Limiter to
+// keep their combined traffic under a single project quota.
+func SharedLimiter() *rate.Limiter {
+	return rate.NewLimiter(NotificationsPerSecond, NotificationsPerSecond)
+}
+
+// Publisher sends push notifications and fetches their receipts. Depend on
+// it rather than on *PushClient to substitute a fake, such as NoopClient.
+type Publisher interface {
+	Publish(ctx context.Context, message *PushMessage, opts ...PublishOption) ([]PushResponse, error)
+	PublishMultiple(ctx context.Context, messages []PushMessage, opts ...PublishOption) ([]PushResponse, error)
+	GetPushNotificationReceipts(ctx context.Context, ids []string) (map[string]PushReceipt, error)
+}
+
+var _ Publisher = (*PushClient)(nil)
+
 // PushClient is an object used for making push notification requests
 type PushClient struct {
-	accessToken  string
-	pushEndpoint string
-	httpClient   *http.Client
+	accessToken       string
+	pushEndpoint      string
+	receiptsEndpoint  string
+	httpClient        *http.Client
+	limiter           *rate.Limiter
+	strict            bool
+	clock             Clock
+	retry             *RetryConfig
+	normalizePriority bool
+	transformer       func(PushMessage) PushMessage
+	debug             io.Writer
+	successStatuses   []string
+	concurrency       int
+	failFast          bool
+	logger            Logger
+	outputMu          *sync.Mutex // Serializes Debug and Logger output, shared by clones
+	onError           func(PushResponse, error)
+	onSuccess         func(PushResponse)
+	timeout           time.Duration
+	skipEmpty         bool
+	chunkSize         int
+	timeoutScale      *TimeoutScale
+	classify          func(details map[string]json.RawMessage) error
+	inFlight          chan struct{}
+	onRateLimit       func(RateLimit)
+	rateLimitLow      int
+	stringifyData     bool
+	requireDisplay    bool
+	stats             *clientStats
+	maxChunkBytes     int
+	marshaler         func([]PushMessage) ([]byte, error)
+	compress          bool
+	httpClients       []*http.Client
+	nextClient        *uint64
+	enforceMaxTTL     bool
+	bodyTransformer   func([]byte) []byte
+	onProgress        func(sent, total int)
+	ttlAsExpiration   bool
+	requireToken      bool
+	trace             bool
+	knownCategories   map[string]bool
+	defaultTTL        int
+	projectOf         func(token string) string
+	tokenRouter       func(PushMessage) string
+	logFailedBodies   bool
+	defaultPriority   string
+}
+
+// Logger receives the client's log lines; a *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
 }
 
 // ClientConfig specifies params that can optionally be specified for alternate
@@ -34,12 +119,202 @@ type ClientConfig struct {
 	APIURL      string
 	AccessToken string
 	HTTPClient  *http.Client
+	// Transport wraps all requests, e.g. for logging or tracing, when no
+	// HTTPClient is given. It is ignored if HTTPClient is set.
+	Transport http.RoundTripper
+	// Strict enables additional opt-in validation of message content
+	Strict bool
+	// Limiter paces sent notifications, one token per notification.
+	// A *rate.Limiter is safe for concurrent use, so sharing one between
+	// clients coordinates them under a single quota, including pauses for
+	// X-RateLimit-* headers. See SharedLimiter.
+	Limiter *rate.Limiter
+	// Clock is the source of time for waits and timestamps, defaulting to the system clock
+	Clock Clock
+	// Retry enables retrying failed push requests; nil disables retries
+	Retry *RetryConfig
+	// NormalizePriority rewrites each message's Priority with NormalizePriority before sending
+	NormalizePriority bool
+	// MessageTransformer, if set, is applied to each message after validation
+	// and before it is encoded, e.g. to inject a default channel or add
+	// analytics to Data. Its output is not validated again.
+	MessageTransformer func(PushMessage) PushMessage
+	// Debug, if set, receives each request and raw response body for
+	// troubleshooting, with push tokens and the Authorization header redacted.
+	// Writes are serialized, also with Logger, so it needn't be safe for
+	// concurrent use.
+	Debug io.Writer
+	// SuccessStatuses lists the response and receipt statuses counted as
+	// success, for relays which report statuses such as "queued".
+	// Defaults to just SuccessStatus.
+	SuccessStatuses []string
+	// Concurrency is the number of chunks sent at once, defaulting to 1
+	Concurrency int
+	// FailFast stops a PublishMultiple call at the first failed chunk,
+	// cancelling the chunks in flight. By default every chunk is sent and
+	// the errors of the failed ones are joined.
+	FailFast bool
+	// Logger, if set, receives a line for each retry and failed chunk,
+	// prefixed with the batch ID of the PublishMultiple call. Calls are
+	// serialized, also with Debug, so it needn't be safe for concurrent use.
+	Logger Logger
+	// OnError, if set, is called for each notification which failed, with
+	// its response and error. Notifications in a chunk which failed as a
+	// whole are reported with a response holding just their message and
+	// the batch ID. With Concurrency above 1 it is called from several
+	// chunks at once, so it must be safe for concurrent use.
+	OnError func(PushResponse, error)
+	// OnSuccess, if set, is called once for each notification Expo
+	// accepted, with its final response after any retries. Like OnError, it
+	// must be safe for concurrent use with Concurrency above 1.
+	OnSuccess func(PushResponse)
+	// RequestTimeout, if set, bounds each HTTP request, including reading its
+	// response. It applies per request, so retries each get the full timeout.
+	RequestTimeout time.Duration
+	// SkipEmptyMessages drops messages with no recipients before sending
+	// instead of failing the whole call with "No recipients". Dropped
+	// messages produce no responses; a call with only empty messages
+	// returns no responses without making a request.
+	SkipEmptyMessages bool
+	// ChunkSize is the number of notifications sent per request, defaulting
+	// to and capped at MaxNotificationsPerRequest
+	ChunkSize int
+	// TimeoutScale, if set, lengthens RequestTimeout for push requests in
+	// proportion to the number of notifications they carry
+	TimeoutScale *TimeoutScale
+	// ClassifyError, if set, is given the details of each failed response
+	// and receipt before the built-in classification, so that error codes
+	// unknown to this package can be mapped to the caller's own error types.
+	// Returning nil falls back to the built-in classification.
+	ClassifyError func(details map[string]json.RawMessage) error
+	// MaxInFlight caps the HTTP requests the client has open at once,
+	// across all concurrent calls and chunks. Zero means no cap.
+	MaxInFlight int
+	// OnRateLimit, if set, is called with the X-RateLimit-* headers of each
+	// response carrying them. With a Limiter, the client, and every other
+	// client sharing the limiter, also pauses until the reported reset once
+	// at most RateLimitThreshold requests remain.
+	// It is called as each response arrives, so it must be safe for
+	// concurrent use with Concurrency above 1.
+	OnRateLimit func(RateLimit)
+	// RateLimitThreshold is the number of remaining requests, as reported by
+	// X-RateLimit-Remaining, at or below which a client with a Limiter pauses
+	// until the reset. It counts requests, not notifications; zero pauses only
+	// once none remain.
+	RateLimitThreshold int
+	// StringifyData sends each message's Data as a JSON-encoded string
+	// rather than an object, for legacy backends which expect that
+	StringifyData bool
+	// RequireDisplayText rejects messages which are neither silent, see
+	// PushMessage.ContentAvailable, nor have a Title, Subtitle or Body,
+	// since such notifications usually display nothing
+	RequireDisplayText bool
+	// MaxChunkBytes, if set, also limits each request body to about this many
+	// bytes, splitting chunks which would be larger. A chunk rejected with a
+	// *PayloadTooLargeError is then split in half and sent again.
+	// A single message larger than the limit is still sent on its own.
+	MaxChunkBytes int
+	// BatchMarshaler, if set, replaces the encoding of each push request
+	// body, including StringifyData, e.g. to rename fields for a self-hosted
+	// relay which doesn't use Expo's field names
+	BatchMarshaler func([]PushMessage) ([]byte, error)
+	// CompressRequests gzips request bodies, sending them with
+	// Content-Encoding: gzip
+	CompressRequests bool
+	// HTTPClients, if set, replaces HTTPClient with a pool of clients used in
+	// rotation, one per request. This helps only at very high throughput,
+	// when a single transport is the bottleneck, e.g. because HTTP/2
+	// multiplexes every request over one connection; give each client its
+	// own Transport so that each has its own connections.
+	HTTPClients []*http.Client
+	// EnforceMaxTTL rejects messages whose TTLSeconds exceeds MaxTTLSeconds
+	// with a *TTLTooLongError, rather than letting the push service clamp it
+	EnforceMaxTTL bool
+	// BodyTransformer, if set, rewrites every request body just before it is
+	// sent, e.g. to wrap or prefix it for a proxy which requires that. It is
+	// applied after compression, so with CompressRequests it receives the
+	// gzipped body. Nothing checks its output: a body which is no longer
+	// valid JSON, or no longer valid gzip, is rejected by Expo.
+	BodyTransformer func([]byte) []byte
+	// OnProgress, if set, is called after each chunk of a PublishMultiple
+	// call completes, whether or not it failed, with the number of
+	// notifications completed so far and the total in the call. Each chunk is
+	// counted once however often it is retried, and calls are serialized so
+	// that sent only grows.
+	OnProgress func(sent, total int)
+	// TTLAsExpiration converts each message's TTLSeconds into an absolute
+	// Expiration when it is sent, by the client's Clock, and clears the TTL,
+	// for backends which handle expirations better than TTLs. Messages which
+	// already have an Expiration are left alone, as Expo gives it precedence.
+	TTLAsExpiration bool
+	// RequireAccessToken makes every request fail with ErrMissingAccessToken,
+	// before anything is sent, if AccessToken is empty. Set it when the
+	// project has Expo's enhanced push security enabled, so that a missing
+	// token is caught locally rather than as a 401 from Expo.
+	RequireAccessToken bool
+	// TraceRequests records the DNS, connect, TLS and first byte timings of
+	// each push request in PushResponse.Timing, to help diagnose latency.
+	// It adds a little overhead to every request, so it is off by default.
+	TraceRequests bool
+	// KnownCategories lists the notification category IDs the app registers.
+	// In strict mode, a message with a CategoryID not in the list is rejected
+	// with an *UnknownCategoryError, since the app would ignore it.
+	// Empty means CategoryID isn't checked.
+	KnownCategories []string
+	// DefaultTTLSeconds, if set, is the TTLSeconds of messages which set
+	// neither TTLSeconds nor Expiration. Messages with an explicit TTL,
+	// including zero, keep it.
+	DefaultTTLSeconds int
+	// ProjectOf, if set, returns the Expo project (experience ID) a token
+	// belongs to, e.g. from where the token is stored. Expo rejects a request
+	// whose tokens belong to more than one project with
+	// PUSH_TOO_MANY_EXPERIENCE_IDS, so messages are then grouped, and split,
+	// by project before chunking. Tokens don't encode their project, so this
+	// can't be derived otherwise; return "" for tokens whose project is
+	// unknown, which are sent together. Responses are returned grouped by
+	// project rather than in the order of the messages.
+	ProjectOf func(token string) string
+	// TokenRouter, if set, chooses the access token each message is sent
+	// with, e.g. by the Expo project of a multi-tenant deployment. Messages
+	// are grouped by token before chunking, and an empty token means
+	// AccessToken. Responses are returned grouped by token, in order of first
+	// appearance, rather than in the order of the messages. PublishAndTrack
+	// polls each receipt with the token its notification was sent with;
+	// GetPushNotificationReceipts and PollReceipts always use AccessToken.
+	TokenRouter func(PushMessage) string
+	// LogFailedBodies logs the request body of each chunk which fails to the
+	// Logger, with push tokens redacted, for post-mortem debugging. Bodies
+	// are encoded only on failure, so successful sends pay nothing for it.
+	LogFailedBodies bool
+	// DefaultPriority, if set, is the Priority of messages which set none.
+	// It must be DefaultPriority, NormalPriority or HighPriority; NewPushClient
+	// ignores anything else, which Validate reports.
+	DefaultPriority string
+}
+
+// Validate reports configuration which NewPushClient would ignore, such as
+// an invalid DefaultPriority, e.g. to reject a bad value read from the
+// environment at startup rather than send without it
+func (config *ClientConfig) Validate() error {
+	switch config.DefaultPriority {
+	case "", DefaultPriority, NormalPriority, HighPriority:
+		return nil
+	}
+	return fmt.Errorf("%w: got %q", ErrInvalidDefaultPriority, config.DefaultPriority)
+}
+
+// TimeoutScale scales the request timeout of a push request by its size.
+// A request with n notifications gets RequestTimeout + n*PerNotification,
+// so RequestTimeout is the floor, capped at Max if Max is set.
+type TimeoutScale struct {
+	PerNotification time.Duration // Extra time allowed per notification in the request
+	Max             time.Duration // Upper bound on the scaled timeout; zero means none
 }
 
 // NewPushClient creates a new Exponent push client
 // See full API docs at https://docs.getexponent.com/versions/v13.0.0/guides/push-notifications.html#http-2-api
 func NewPushClient(config *ClientConfig) *PushClient {
-	c := &PushClient{}
+	c := &PushClient{clock: systemClock{}, concurrency: 1, chunkSize: MaxNotificationsPerRequest, stats: &clientStats{}, nextClient: new(uint64), outputMu: &sync.Mutex{}}
 	host := DefaultHost
 	apiURL := DefaultBaseAPIURL
 	httpClient := DefaultHTTPClient
@@ -56,94 +331,1045 @@ func NewPushClient(config *ClientConfig) *PushClient {
 		}
 		if config.HTTPClient != nil {
 			httpClient = config.HTTPClient
+		} else if config.Transport != nil {
+			httpClient = &http.Client{Transport: config.Transport}
+		}
+		c.limiter = config.Limiter
+		c.strict = config.Strict
+		if config.Clock != nil {
+			c.clock = config.Clock
+		}
+		c.retry = config.Retry
+		c.normalizePriority = config.NormalizePriority
+		c.transformer = config.MessageTransformer
+		c.debug = config.Debug
+		c.successStatuses = config.SuccessStatuses
+		if config.Concurrency > 0 {
+			c.concurrency = config.Concurrency
+		}
+		c.failFast = config.FailFast
+		c.logger = config.Logger
+		c.onError = config.OnError
+		c.onSuccess = config.OnSuccess
+		c.timeout = config.RequestTimeout
+		c.timeoutScale = config.TimeoutScale
+		c.classify = config.ClassifyError
+		c.onRateLimit = config.OnRateLimit
+		c.rateLimitLow = config.RateLimitThreshold
+		c.stringifyData = config.StringifyData
+		c.requireDisplay = config.RequireDisplayText
+		c.maxChunkBytes = config.MaxChunkBytes
+		c.marshaler = config.BatchMarshaler
+		c.compress = config.CompressRequests
+		c.httpClients = config.HTTPClients
+		c.enforceMaxTTL = config.EnforceMaxTTL
+		c.bodyTransformer = config.BodyTransformer
+		c.onProgress = config.OnProgress
+		c.ttlAsExpiration = config.TTLAsExpiration
+		c.requireToken = config.RequireAccessToken
+		c.trace = config.TraceRequests
+		c.defaultTTL = config.DefaultTTLSeconds
+		c.projectOf = config.ProjectOf
+		c.tokenRouter = config.TokenRouter
+		c.logFailedBodies = config.LogFailedBodies
+		if config.Validate() == nil {
+			c.defaultPriority = config.DefaultPriority
+		}
+		if len(config.KnownCategories) > 0 {
+			c.knownCategories = make(map[string]bool, len(config.KnownCategories))
+			for _, id := range config.KnownCategories {
+				c.knownCategories[id] = true
+			}
+		}
+		if config.MaxInFlight > 0 {
+			c.inFlight = make(chan struct{}, config.MaxInFlight)
+		}
+		c.skipEmpty = config.SkipEmptyMessages
+		if config.ChunkSize > 0 && config.ChunkSize < MaxNotificationsPerRequest {
+			c.chunkSize = config.ChunkSize
 		}
 	}
 	c.httpClient = httpClient
 	c.accessToken = accessToken
-	sb := &strings.Builder{}
-	sb.WriteString(host)
-	sb.WriteString(apiURL)
-	sb.WriteString("/push/send")
-	c.pushEndpoint = sb.String()
+	c.pushEndpoint = host + apiURL + "/push/send"
+	c.receiptsEndpoint = host + apiURL + "/push/getReceipts"
 	return c
 }
 
+// requestTimeout returns the timeout of a request carrying n notifications,
+// or zero if requests have no timeout
+func (c *PushClient) requestTimeout(n int) time.Duration {
+	if c.timeout <= 0 || c.timeoutScale == nil {
+		return c.timeout
+	}
+	timeout := c.timeout + time.Duration(n)*c.timeoutScale.PerNotification
+	if c.timeoutScale.Max > 0 && timeout > c.timeoutScale.Max {
+		timeout = c.timeoutScale.Max
+	}
+	if timeout < c.timeout {
+		timeout = c.timeout
+	}
+	return timeout
+}
+
+// requestContext bounds ctx by the timeout of a request carrying n
+// notifications. Context deadlines are in wall time, so the client's clock
+// isn't used.
+func (c *PushClient) requestContext(ctx context.Context, n int) (context.Context, context.CancelFunc) {
+	timeout := c.requestTimeout(n)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ClientStats is a running estimate of a client's usage, for cheap built-in
+// observability. Clients made with WithTimeout share their parent's stats.
+type ClientStats struct {
+	Sent    int64 // Notifications Expo accepted
+	Failed  int64 // Notifications rejected by Expo or in chunks which failed as a whole
+	Retried int64 // Retried requests and rate limited resends
+	Bytes   int64 // Request and decoded response body bytes transferred, including receipts
+}
+
+// clientStats holds the counters behind ClientStats, updated atomically
+type clientStats struct {
+	sent, failed, retried, bytes int64
+}
+
+// Stats returns a snapshot of the client's usage counters
+func (c *PushClient) Stats() ClientStats {
+	return ClientStats{
+		Sent:    atomic.LoadInt64(&c.stats.sent),
+		Failed:  atomic.LoadInt64(&c.stats.failed),
+		Retried: atomic.LoadInt64(&c.stats.retried),
+		Bytes:   atomic.LoadInt64(&c.stats.bytes),
+	}
+}
+
+// WithTimeout returns a copy of the client which bounds each request by d,
+// replacing ClientConfig.RequestTimeout. A deadline already on the caller's
+// context still applies, so whichever is sooner wins.
+func (c *PushClient) WithTimeout(d time.Duration) *PushClient {
+	clone := *c
+	clone.timeout = d
+	return &clone
+}
+
+// SendURL returns the endpoint push notifications are sent to
+func (c *PushClient) SendURL() string {
+	return c.pushEndpoint
+}
+
+// ReceiptsURL returns the endpoint push receipts are fetched from
+func (c *PushClient) ReceiptsURL() string {
+	return c.receiptsEndpoint
+}
+
 // Publish sends a single push notification
 // @param push_message: A PushMessage object
 // @return an array of PushResponse objects which contains the results (one per each recipient).
 // @return error if any requests failed
-func (c *PushClient) Publish(ctx context.Context, message *PushMessage) ([]PushResponse, error) {
-	responses, err := c.PublishMultiple(ctx, []PushMessage{*message})
+func (c *PushClient) Publish(ctx context.Context, message *PushMessage, opts ...PublishOption) ([]PushResponse, error) {
+	return c.PublishMultiple(ctx, []PushMessage{*message}, opts...)
+}
+
+// SendSimple sends a single notification with a title and body to token,
+// for examples and first-time setups. The token is validated before sending.
+// The error is the request's error or, if Expo rejected the notification,
+// the response's error from ValidateResponse.
+func SendSimple(ctx context.Context, client *PushClient, token, title, body string) (PushResponse, error) {
+	if _, err := NewExponentPushToken(token); err != nil {
+		return PushResponse{}, fmt.Errorf("token %q: %w", token, err)
+	}
+	responses, err := client.Publish(ctx, &PushMessage{To: []string{token}, Title: title, Body: body})
 	if err != nil {
-		return nil, err
+		return PushResponse{}, err
 	}
-	return responses, nil
+	if len(responses) != 1 {
+		return PushResponse{}, fmt.Errorf("expected 1 response, got %d", len(responses))
+	}
+	return responses[0], responses[0].ValidateResponse()
 }
 
 // PublishMultiple sends multiple push notifications at once
+// Messages are sent in chunks, ClientConfig.Concurrency at a time.
 // @param push_messages: An array of PushMessage objects.
-// @return an array of PushResponse objects which contains the results.
-// @return error if the request failed
-func (c *PushClient) PublishMultiple(ctx context.Context, messages []PushMessage) ([]PushResponse, error) {
-	return c.publishInternal(ctx, messages)
+// @return an array of PushResponse objects which contains the results of the successful chunks,
+// and those salvaged from a failed chunk with a short response, see ResponseLengthMismatchError.
+// @return error joining a *ChunkError for each failed chunk, or only the first with ClientConfig.FailFast
+// An empty messages slice returns an empty array and no error without making a request.
+// Responses follow the order of the messages, except that with ClientConfig.TokenRouter or
+// ClientConfig.ProjectOf they are grouped by access token or project first.
+func (c *PushClient) PublishMultiple(ctx context.Context, messages []PushMessage, opts ...PublishOption) ([]PushResponse, error) {
+	result := c.PublishMultipleResult(ctx, messages, opts...)
+	return result.Responses, result.Err
+}
+
+// PublishMultipleResult is the outcome of a PublishMultiple call with batch level stats
+type PublishMultipleResult struct {
+	Responses    []PushResponse // Responses of the successful chunks, as returned by PublishMultiple
+	Err          error          // Error as returned by PublishMultiple
+	BatchID      string         // Batch ID of the call, see WithBatchID
+	Chunks       int            // Chunks sent, including failed ones
+	FailedChunks int            // Chunks which failed as a whole
+	Retries      int            // Retried requests and rate limited resends, across all chunks
+	Duration     time.Duration  // Time taken by the call, by the client's clock
+}
+
+// PublishMultipleResult sends messages like PublishMultiple, returning the
+// responses and error together with stats about the call
+func (c *PushClient) PublishMultipleResult(ctx context.Context, messages []PushMessage, opts ...PublishOption) PublishMultipleResult {
+	var options publishOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	start := c.clock.Now()
+	result := c.publishInternal(ctx, messages, options)
+	result.Duration = c.clock.Now().Sub(start)
+	return result
+}
+
+// PublishValid sends the messages which pass validation like PublishMultiple,
+// so that one bad message doesn't block the whole batch. The problems with the
+// messages left out are returned in invalid, as by ValidateAll, with indices
+// into messages; the responses are those of the valid messages only, in order.
+func (c *PushClient) PublishValid(ctx context.Context, messages []PushMessage, opts ...PublishOption) (responses []PushResponse, invalid []error, err error) {
+	valid := make([]PushMessage, 0, len(messages))
+	for i, message := range messages {
+		if c.skipEmpty && len(message.To) == 0 {
+			continue
+		}
+		if errs := c.messageErrors(i, message); len(errs) > 0 {
+			invalid = append(invalid, errs...)
+			continue
+		}
+		valid = append(valid, message)
+	}
+	responses, err = c.PublishMultiple(ctx, valid, opts...)
+	return responses, invalid, err
+}
+
+// PublishMultipleMap sends messages like PublishMultiple, returning the
+// responses keyed by recipient token. If a token appears more than once, the
+// response of its last notification wins.
+func (c *PushClient) PublishMultipleMap(ctx context.Context, messages []PushMessage, opts ...PublishOption) (map[string]PushResponse, error) {
+	responses, err := c.PublishMultiple(ctx, messages, opts...)
+	byToken := make(map[string]PushResponse, len(responses))
+	for _, r := range responses {
+		byToken[r.PushMessage.To[0]] = r
+	}
+	return byToken, err
+}
+
+// PublishOption configures a single Publish or PublishMultiple call
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	batchID string
+}
+
+// WithBatchID tags every response, log line and callback of a call with id,
+// so that one logical send can be traced across its chunks and retries.
+// Without it a random batch ID is generated for each call.
+func WithBatchID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.batchID = id
+	}
+}
+
+// newBatchID returns a random batch ID
+func newBatchID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// batch holds the state shared by the chunks of one PublishMultiple call
+type batch struct {
+	id      string
+	budget  *retryBudget
+	retries int64 // Retries made, updated atomically
+}
+
+// logf writes a line to the configured logger, if any
+func (c *PushClient) logf(format string, v ...interface{}) {
+	if c.logger != nil {
+		c.outputMu.Lock()
+		defer c.outputMu.Unlock()
+		c.logger.Printf(format, v...)
+	}
+}
+
+// reportResponses passes each failed response to the OnError callback and
+// each successful one to the OnSuccess callback, if they are configured
+func (c *PushClient) reportResponses(responses []PushResponse) {
+	if c.onError == nil && c.onSuccess == nil {
+		return
+	}
+	for _, r := range responses {
+		err := r.ValidateResponse()
+		if err != nil && c.onError != nil {
+			c.onError(r, err)
+		} else if err == nil && c.onSuccess != nil {
+			c.onSuccess(r)
+		}
+	}
+}
+
+// reportChunkError passes each notification of a failed chunk to the OnError
+// callback, if one is configured, skipping the first received which have responses
+func (c *PushClient) reportChunkError(b *batch, index int, chunk []PushMessage, received int, err error) {
+	if c.onError == nil {
+		return
+	}
+	for _, msg := range unsent(chunk, received) {
+		for _, to := range msg.To {
+			r := PushResponse{PushMessage: msg, Metadata: msg.Metadata, BatchID: b.id, ChunkIndex: index}
+			r.PushMessage.To = []string{to}
+			c.onError(r, err)
+		}
+	}
+}
+
+// unsent returns the messages of chunk for the notifications after the first
+// received, which have responses, splitting a message answered in part
+func unsent(chunk []PushMessage, received int) []PushMessage {
+	var messages []PushMessage
+	for _, msg := range chunk {
+		if received >= len(msg.To) {
+			received -= len(msg.To)
+			continue
+		}
+		msg.To = msg.To[received:]
+		received = 0
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// prepare applies the client's normalization to a copy of messages,
+// trimming whitespace around recipients, splitting messages with more than
+// MaxRecipientsPerMessage recipients, dropping those with none if
+// SkipEmptyMessages is set and applying DefaultPriority and DefaultTTLSeconds
+func (c *PushClient) prepare(messages []PushMessage) []PushMessage {
+	if c.skipEmpty {
+		messages = dropEmpty(messages)
+	}
+	messages = NormalizeRecipients(trimRecipients(messages))
+	if !c.normalizePriority && c.defaultTTL <= 0 && c.defaultPriority == "" {
+		return messages
+	}
+	prepared := make([]PushMessage, len(messages))
+	copy(prepared, messages)
+	for i := range prepared {
+		if prepared[i].Priority == "" {
+			prepared[i].Priority = c.defaultPriority
+		}
+		if c.normalizePriority {
+			prepared[i].Priority = NormalizePriority(prepared[i].Priority)
+		}
+		// An expiration already bounds delivery, and takes precedence over a TTL
+		if c.defaultTTL > 0 && prepared[i].TTLSeconds == nil && prepared[i].Expiration == 0 {
+			prepared[i].TTLSeconds = Int(c.defaultTTL)
+		}
+	}
+	return prepared
+}
+
+// trimRecipients trims leading and trailing whitespace from the recipients of
+// messages, copying only the messages which need it
+func trimRecipients(messages []PushMessage) []PushMessage {
+	var trimmed []PushMessage
+	for i, message := range messages {
+		padded := false
+		for _, to := range message.To {
+			padded = padded || strings.TrimSpace(to) != to
+		}
+		if !padded {
+			continue
+		}
+		if trimmed == nil {
+			trimmed = make([]PushMessage, len(messages))
+			copy(trimmed, messages)
+		}
+		to := make([]string, len(message.To))
+		for j, recipient := range message.To {
+			to[j] = strings.TrimSpace(recipient)
+		}
+		trimmed[i].To = to
+	}
+	if trimmed == nil {
+		return messages
+	}
+	return trimmed
+}
+
+// dropEmpty returns the messages which have at least one recipient
+func dropEmpty(messages []PushMessage) []PushMessage {
+	kept := make([]PushMessage, 0, len(messages))
+	for _, message := range messages {
+		if len(message.To) > 0 {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+// transform applies the configured MessageTransformer to a copy of messages,
+// then, with TTLAsExpiration, converts their TTLs to expirations.
+// Messages the transformer leaves without recipients are dropped, so that they
+// are not sent and contribute nothing to the expected number of responses.
+func (c *PushClient) transform(messages []PushMessage) []PushMessage {
+	if c.transformer == nil && !c.ttlAsExpiration {
+		return messages
+	}
+	now := c.clock.Now()
+	transformed := make([]PushMessage, 0, len(messages))
+	for _, message := range messages {
+		if c.transformer != nil {
+			message = c.transformer(message)
+		}
+		if len(message.To) == 0 {
+			continue
+		}
+		if c.ttlAsExpiration && message.TTLSeconds != nil && message.Expiration == 0 {
+			message.Expiration = now.Add(time.Duration(*message.TTLSeconds) * time.Second).Unix()
+			message.TTLSeconds = nil
+		}
+		transformed = append(transformed, message)
+	}
+	return transformed
 }
 
 // validate checks that the messages are valid
 // valid messages have at least one recipient and all recipients have a valid push token
+// with RequireDisplayText, non-silent messages must have display text
+// in strict mode, display fields must also be free of control characters,
+// background pushes must have no display text, sound or badge
+// the expiration, if set, must not have passed
+// and the category, if KnownCategories is set, must be known
 func (c *PushClient) validate(messages []PushMessage) (int, error) {
 	var count int
 	// Validate the messages
-	for _, message := range messages {
+	for i, message := range messages {
 		if len(message.To) == 0 {
 			return 0, errors.New("No recipients")
 		}
-		for _, recipient := range message.To {
-			if !strings.HasPrefix(recipient, "ExponentPushToken") {
+		for j, recipient := range message.To {
+			// Recipients were trimmed by prepare
+			switch tokenError(recipient) {
+			case nil:
+			case ErrTokenWhitespace:
+				return 0, &ValidationError{Index: i, Field: fmt.Sprintf("To[%d]", j), Err: ErrTokenWhitespace}
+			default:
 				return 0, errors.New("Invalid push token")
 			}
 		}
+		if err := c.validateDisplayText(i, message); err != nil {
+			return 0, err
+		}
+		if err := c.validateTTL(i, message); err != nil {
+			return 0, err
+		}
+		if err := validateCollapseID(i, message); err != nil {
+			return 0, err
+		}
+		if c.strict {
+			if err := validateContent(i, message); err != nil {
+				return 0, err
+			}
+			if err := c.validateExpiration(i, message); err != nil {
+				return 0, err
+			}
+			if err := c.validateCategory(i, message); err != nil {
+				return 0, err
+			}
+		}
 		count += len(message.To)
 	}
 	return count, nil
 }
 
-func (c *PushClient) buildRequest(ctx context.Context, messages []PushMessage) (*http.Request, error) {
-	jsonBytes, err := json.Marshal(messages)
-	if err != nil {
+// ValidateAll checks every message and returns all of the problems found,
+// each as a *ValidationError naming the message index and field, rather
+// than stopping at the first. Strict checks apply if the client is strict.
+func (c *PushClient) ValidateAll(messages []PushMessage) []error {
+	var errs []error
+	for i, message := range messages {
+		errs = append(errs, c.messageErrors(i, message)...)
+	}
+	return errs
+}
+
+// ValidateAll checks every message with the default, non-strict validation
+// and returns all of the problems found. See PushClient.ValidateAll.
+func ValidateAll(messages []PushMessage) []error {
+	return NewPushClient(nil).ValidateAll(messages)
+}
+
+// messageErrors returns every validation problem with a single message
+func (c *PushClient) messageErrors(index int, message PushMessage) []error {
+	var errs []error
+	if len(message.To) == 0 {
+		errs = append(errs, &ValidationError{Index: index, Field: "To", Err: ErrNoRecipients})
+	}
+	for j, recipient := range message.To {
+		if err := tokenError(strings.TrimSpace(recipient)); err != nil {
+			errs = append(errs, &ValidationError{Index: index, Field: fmt.Sprintf("To[%d]", j), Err: err})
+		}
+	}
+	if err := c.validateDisplayText(index, message); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.validateTTL(index, message); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateCollapseID(index, message); err != nil {
+		errs = append(errs, err)
+	}
+	if c.strict {
+		if err := validateContent(index, message); err != nil {
+			errs = append(errs, err)
+		}
+		if err := c.validateExpiration(index, message); err != nil {
+			errs = append(errs, err)
+		}
+		if err := c.validateCategory(index, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateDisplayText checks, if display text is required, that a message
+// which isn't silent has something to display
+func (c *PushClient) validateDisplayText(index int, message PushMessage) error {
+	if !c.requireDisplay || message.ContentAvailable {
+		return nil
+	}
+	if !message.hasAlertContent() {
+		return &ValidationError{Index: index, Field: "Body", Err: ErrNoDisplayText}
+	}
+	return nil
+}
+
+// validateTTL checks, if the maximum TTL is enforced, that a message's TTL is within it
+func (c *PushClient) validateTTL(index int, message PushMessage) error {
+	if !c.enforceMaxTTL || message.TTLSeconds == nil || *message.TTLSeconds <= MaxTTLSeconds {
+		return nil
+	}
+	return &ValidationError{Index: index, Field: "TTLSeconds", Err: &TTLTooLongError{TTLSeconds: *message.TTLSeconds, Max: MaxTTLSeconds}}
+}
+
+// validateCollapseID checks that a message's APNs collapse ID is within the APNs limit.
+// FCM documents no length limit for collapse keys, so AndroidCollapseKey isn't checked.
+func validateCollapseID(index int, message PushMessage) error {
+	if len(message.APNSCollapseID) > MaxAPNSCollapseIDBytes {
+		return &ValidationError{Index: index, Field: "APNSCollapseID", Err: ErrCollapseIDTooLong}
+	}
+	return nil
+}
+
+// validateContent checks the display fields of a message for control characters,
+// and that a background push has nothing which would make it an alert, see
+// APNSPushType. Newlines are allowed in the body only.
+func validateContent(index int, message PushMessage) error {
+	if message.ContentAvailable && message.APNSPushType() != APNSPushTypeBackground {
+		return &ValidationError{Index: index, Field: "ContentAvailable", Err: ErrBackgroundAlert}
+	}
+	fields := []struct {
+		name     string
+		value    string
+		newlines bool
+	}{
+		{"Title", message.Title, false},
+		{"Subtitle", message.Subtitle, false},
+		{"Body", message.Body, true},
+	}
+	for _, f := range fields {
+		for _, r := range f.value {
+			if r == '\n' && f.newlines {
+				continue
+			}
+			if unicode.IsControl(r) {
+				return &ValidationError{Index: index, Field: f.name, Err: ErrControlCharacter}
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalBatch returns the JSON request bodies the client would send for messages,
+// one per chunk, using the same validation, chunking and encoding as PublishMultiple.
+func (c *PushClient) MarshalBatch(messages []PushMessage) ([][]byte, error) {
+	messages = c.prepare(messages)
+	if _, err := c.validate(messages); err != nil {
 		return nil, err
 	}
+	messages = c.transform(messages)
+	chunks := c.chunk(messages)
+	bodies := make([][]byte, 0, len(chunks))
+	for _, chunk := range chunks {
+		body, err := c.marshalChunk(chunk)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
 
+// PlanRequests returns the number of push requests PublishMultiple would make
+// for messages, by running the same validation and chunking without sending,
+// e.g. for capacity planning or sizing a progress bar. Retries, and chunks
+// split after Expo rejects them as too large, are not counted.
+func (c *PushClient) PlanRequests(messages []PushMessage) (chunks int, err error) {
+	messages = c.prepare(messages)
+	if _, err := c.validate(messages); err != nil {
+		return 0, err
+	}
+	messages = c.transform(messages)
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	return len(c.chunk(messages)), nil
+}
+
+// chunk splits messages into the chunks the client sends, by access token if
+// TokenRouter is set, by project if ProjectOf is set, then by ChunkSize and
+// then, if set, MaxChunkBytes. Messages with more than ChunkSize recipients
+// are split first, so that no chunk carries more than ChunkSize notifications.
+func (c *PushClient) chunk(messages []PushMessage) [][]PushMessage {
+	messages = splitRecipients(messages, c.chunkSize)
+	groups := [][]PushMessage{messages}
+	if c.tokenRouter != nil {
+		groups = groupByToken(messages, c.tokenRouter)
+	}
+	if c.projectOf != nil {
+		var byProject [][]PushMessage
+		for _, group := range groups {
+			byProject = append(byProject, partitionByProject(group, c.projectOf)...)
+		}
+		groups = byProject
+	}
+	var chunks [][]PushMessage
+	for _, group := range groups {
+		chunks = append(chunks, ChunkMessages(group, c.chunkSize)...)
+	}
+	if c.maxChunkBytes <= 0 {
+		return chunks
+	}
+	var split [][]PushMessage
+	for _, chunk := range chunks {
+		split = append(split, c.chunkBytes(chunk)...)
+	}
+	return split
+}
+
+// groupByToken groups messages by the access token router chooses for them,
+// ordered by first appearance and keeping the order of messages in a group
+func groupByToken(messages []PushMessage, router func(PushMessage) string) [][]PushMessage {
+	index := map[string]int{}
+	var groups [][]PushMessage
+	for _, message := range messages {
+		token := router(message)
+		i, ok := index[token]
+		if !ok {
+			i = len(groups)
+			index[token] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], message)
+	}
+	return groups
+}
+
+// accessTokenFor returns the access token to send a chunk with, which
+// TokenRouter chooses if set; chunks never mix tokens
+func (c *PushClient) accessTokenFor(chunk []PushMessage) string {
+	if c.tokenRouter != nil && len(chunk) > 0 {
+		if token := c.tokenRouter(chunk[0]); token != "" {
+			return token
+		}
+	}
+	return c.accessToken
+}
+
+// partitionByProject groups messages by the project of their recipients,
+// splitting messages whose recipients belong to several, so that no group
+// mixes projects. Groups are ordered by first appearance, and messages and
+// recipients keep their order within a group. Recipients whose project
+// projectOf can't tell, returned as "", are grouped together.
+func partitionByProject(messages []PushMessage, projectOf func(token string) string) [][]PushMessage {
+	index := map[string]int{}
+	var groups [][]PushMessage
+	for _, message := range messages {
+		// Split the message's recipients by project, in order
+		var projects []string
+		byProject := map[string][]string{}
+		for _, to := range message.To {
+			project := projectOf(to)
+			if _, ok := byProject[project]; !ok {
+				projects = append(projects, project)
+			}
+			byProject[project] = append(byProject[project], to)
+		}
+		for _, project := range projects {
+			part := message
+			part.To = byProject[project]
+			i, ok := index[project]
+			if !ok {
+				i = len(groups)
+				index[project] = i
+				groups = append(groups, nil)
+			}
+			groups[i] = append(groups[i], part)
+		}
+	}
+	return groups
+}
+
+// chunkBytes splits a chunk into chunks whose encoding is at most
+// MaxChunkBytes, preserving order. A message larger than the limit is placed
+// in a chunk of its own.
+func (c *PushClient) chunkBytes(messages []PushMessage) [][]PushMessage {
+	var chunks [][]PushMessage
+	var current []PushMessage
+	size := 2 // Brackets of the JSON array
+	for _, message := range messages {
+		encoded, _ := c.marshalChunk([]PushMessage{message})
+		messageSize := len(encoded) - 1 // Less the brackets, plus a separating comma
+		if len(current) > 0 && size+messageSize > c.maxChunkBytes {
+			chunks = append(chunks, current)
+			current = nil
+			size = 2
+		}
+		current = append(current, message)
+		size += messageSize
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// ChunkMessages splits messages into chunks of at most size notifications,
+// preserving their order, as the client does before sending, e.g. to inspect
+// or parallelize chunks yourself. A message with more recipients than size is
+// placed in a chunk of its own; the client splits such messages beforehand,
+// see NormalizeRecipients. A size of zero or less means MaxNotificationsPerRequest.
+func ChunkMessages(messages []PushMessage, size int) [][]PushMessage {
+	if size <= 0 {
+		size = MaxNotificationsPerRequest
+	}
+	var chunks [][]PushMessage
+	var current []PushMessage
+	count := 0
+	for _, message := range messages {
+		if len(current) > 0 && count+len(message.To) > size {
+			chunks = append(chunks, current)
+			current = nil
+			count = 0
+		}
+		current = append(current, message)
+		count += len(message.To)
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// countNotifications returns the number of notifications, one per recipient, in messages
+func countNotifications(messages []PushMessage) int {
+	var count int
+	for _, message := range messages {
+		count += len(message.To)
+	}
+	return count
+}
+
+// marshalChunk encodes a chunk of messages as a push/send request body
+func (c *PushClient) marshalChunk(messages []PushMessage) ([]byte, error) {
+	if c.marshaler != nil {
+		return c.marshaler(messages)
+	}
+	if !c.stringifyData {
+		return json.Marshal(messages)
+	}
+	stringified := make([]stringDataMessage, len(messages))
+	for i, message := range messages {
+		stringified[i].PushMessage = message
+		if message.Data != nil {
+			data, err := json.Marshal(message.Data)
+			if err != nil {
+				return nil, err
+			}
+			stringified[i].Data = string(data)
+		}
+	}
+	return json.Marshal(stringified)
+}
+
+// stringDataMessage encodes a PushMessage with its Data as a JSON string.
+// The outer Data field takes precedence over the embedded one.
+type stringDataMessage struct {
+	PushMessage
+	Data string `json:"data,omitempty"`
+}
+
+// validateExpiration checks that a message's expiration hasn't already passed
+func (c *PushClient) validateExpiration(index int, message PushMessage) error {
+	if message.Expiration != 0 && message.Expiration <= c.clock.Now().Unix() {
+		return &ValidationError{Index: index, Field: "Expiration", Err: ErrExpired}
+	}
+	return nil
+}
+
+// validateCategory checks, if the known categories are set, that a message's category is one of them
+func (c *PushClient) validateCategory(index int, message PushMessage) error {
+	if c.knownCategories == nil || message.CategoryID == "" || c.knownCategories[message.CategoryID] {
+		return nil
+	}
+	return &ValidationError{Index: index, Field: "CategoryID", Err: &UnknownCategoryError{CategoryID: message.CategoryID}}
+}
+
+// buildRequest creates a POST of body to url. The body is sent with a fixed
+// Content-Length, never chunked, and is replayable through GetBody, also
+// when it is compressed.
+func (c *PushClient) buildRequest(ctx context.Context, url string, body []byte) (*http.Request, error) {
+	return c.buildTokenRequest(ctx, url, body, c.accessToken)
+}
+
+// buildTokenRequest is buildRequest authorized with accessToken rather than the client's
+func (c *PushClient) buildTokenRequest(ctx context.Context, url string, body []byte, accessToken string) (*http.Request, error) {
+	if c.requireToken && accessToken == "" {
+		return nil, ErrMissingAccessToken
+	}
+	if c.compress {
+		var err error
+		body, err = gzipBody(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.bodyTransformer != nil {
+		body = c.bodyTransformer(body)
+	}
 	// Create request w/ body
-	req, err := http.NewRequestWithContext(ctx, "POST", c.pushEndpoint, bytes.NewReader(jsonBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
 	// Add appropriate headers
 	req.Header.Add("Content-Type", "application/json")
-	if c.accessToken != "" {
-		req.Header.Add("Authorization", "Bearer "+c.accessToken)
+	if c.compress {
+		req.Header.Add("Content-Encoding", "gzip")
+	}
+	req.Header.Add("Accept-Encoding", "gzip")
+	if accessToken != "" {
+		req.Header.Add("Authorization", "Bearer "+accessToken)
 	}
 	return req, nil
 }
 
-func (c *PushClient) publishInternal(ctx context.Context, messages []PushMessage) ([]PushResponse, error) {
+func (c *PushClient) publishInternal(ctx context.Context, messages []PushMessage, options publishOptions) PublishMultipleResult {
+	// Nothing to send
+	if len(messages) == 0 {
+		return PublishMultipleResult{Responses: []PushResponse{}}
+	}
+	messages = c.prepare(messages)
+	if len(messages) == 0 {
+		return PublishMultipleResult{Responses: []PushResponse{}}
+	}
 	// Validate the messages
-	expectedReceipts, err := c.validate(messages)
+	_, err := c.validate(messages)
 	if err != nil {
-		return nil, err
+		return PublishMultipleResult{Err: err}
+	}
+	// With a router, each chunk's token is checked as it is sent
+	if c.requireToken && c.accessToken == "" && c.tokenRouter == nil {
+		return PublishMultipleResult{Err: ErrMissingAccessToken}
+	}
+	messages = c.transform(messages)
+	if len(messages) == 0 {
+		return PublishMultipleResult{Responses: []PushResponse{}}
+	}
+	// Send the chunks, sharing one batch ID and retry budget
+	b := &batch{id: options.batchID, budget: newRetryBudget(c.retry)}
+	if b.id == "" {
+		b.id = newBatchID()
+	}
+	chunks := c.chunk(messages)
+	results := make([][]PushResponse, len(chunks))
+	errs := make([]error, len(chunks))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var firstErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+	sent := 0
+	sem := make(chan struct{}, c.concurrency)
+	progress := c.newProgress(countNotifications(messages))
+	for i, chunk := range chunks {
+		if c.failFast && ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		sent++
+		go func(i int, chunk []PushMessage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer progress.add(countNotifications(chunk))
+			chunkResponses, err := c.sendChunk(ctx, b, i, chunk)
+			for j := range chunkResponses {
+				chunkResponses[j].ChunkIndex = i
+			}
+			results[i] = chunkResponses
+			if err != nil {
+				errs[i] = &ChunkError{Index: i, Messages: unsent(chunk, len(chunkResponses)), Err: err}
+				c.logf("batch %s: chunk %d failed: %v", b.id, i, err)
+				c.logFailedBody(b, i, chunk)
+				c.reportResponses(chunkResponses)
+				c.reportChunkError(b, i, chunk, len(chunkResponses), err)
+				if c.failFast {
+					once.Do(func() {
+						firstErr = errs[i]
+						cancel()
+					})
+				}
+				return
+			}
+			c.reportResponses(chunkResponses)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	result := PublishMultipleResult{
+		Responses: make([]PushResponse, 0, countNotifications(messages)),
+		BatchID:   b.id,
+		Chunks:    sent,
+		Retries:   int(atomic.LoadInt64(&b.retries)),
 	}
+	var sentCount, failedCount int64
+	for i, chunkResponses := range results {
+		result.Responses = append(result.Responses, chunkResponses...)
+		if errs[i] != nil {
+			result.FailedChunks++
+			failedCount += int64(countNotifications(chunks[i]) - len(chunkResponses))
+		}
+		for _, r := range chunkResponses {
+			if r.isSuccess() {
+				sentCount++
+			} else {
+				failedCount++
+			}
+		}
+	}
+	atomic.AddInt64(&c.stats.sent, sentCount)
+	atomic.AddInt64(&c.stats.failed, failedCount)
+	atomic.AddInt64(&c.stats.retried, int64(result.Retries))
+	result.Err = firstErr
+	if result.Err == nil {
+		result.Err = errors.Join(errs...)
+	}
+	return result
+}
+
+// progress reports the notifications completed in a call to OnProgress
+type progress struct {
+	mu         sync.Mutex
+	onProgress func(sent, total int)
+	sent       int
+	total      int
+}
+
+func (c *PushClient) newProgress(total int) *progress {
+	return &progress{onProgress: c.onProgress, total: total}
+}
+
+// add counts n more notifications as completed and reports the new count
+func (p *progress) add(n int) {
+	if p.onProgress == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent += n
+	p.onProgress(p.sent, p.total)
+}
+
+// sendChunk publishes a chunk, retrying failed requests and rate limited notifications
+func (c *PushClient) sendChunk(ctx context.Context, b *batch, index int, chunk []PushMessage) ([]PushResponse, error) {
+	responses, err := c.withRetries(ctx, b, index, func() ([]PushResponse, error) {
+		return c.publishChunk(ctx, b, chunk)
+	})
+	var tooLarge *PayloadTooLargeError
+	if errors.As(err, &tooLarge) && c.maxChunkBytes > 0 && len(chunk) > 1 {
+		return c.sendHalves(ctx, b, index, chunk)
+	}
+	if err != nil {
+		// Keep any responses salvaged from a short response
+		return responses, err
+	}
+	c.retryRateLimited(ctx, b, responses)
+	return responses, nil
+}
+
+// sendHalves sends a chunk which was too large as two chunks of half the size.
+// The second half is only sent once the first has succeeded, so the responses
+// returned on failure are those of the leading notifications, and the unsent
+// remainder of the chunk is what follows them, see unsent.
+func (c *PushClient) sendHalves(ctx context.Context, b *batch, index int, chunk []PushMessage) ([]PushResponse, error) {
+	c.logf("batch %s: chunk %d too large, splitting %d messages", b.id, index, len(chunk))
+	half := len(chunk) / 2
+	first, err := c.sendChunk(ctx, b, index, chunk[:half])
+	if err != nil {
+		return first, err
+	}
+	second, err := c.sendChunk(ctx, b, index, chunk[half:])
+	return append(first, second...), err
+}
+
+// publishChunk sends a single request containing messages
+func (c *PushClient) publishChunk(ctx context.Context, b *batch, messages []PushMessage) ([]PushResponse, error) {
+	expectedReceipts := countNotifications(messages)
 	// Build request
-	req, err := c.buildRequest(ctx, messages)
+	body, err := c.marshalChunk(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for our share of the rate limit
+	err = c.wait(ctx, expectedReceipts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.requestContext(ctx, expectedReceipts)
+	defer cancel()
+	accessToken := c.accessTokenFor(messages)
+	req, err := c.buildTokenRequest(ctx, c.pushEndpoint, body, accessToken)
 	if err != nil {
 		return nil, err
 	}
+	var trace *requestTrace
+	if c.trace {
+		req, trace = traceRequest(req)
+	}
 
 	// Send request
-	resp, err := c.httpClient.Do(req)
+	sentAt := c.clock.Now()
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	// Check that we didn't receive an invalid response
 	err = checkStatus(resp)
@@ -167,26 +1393,233 @@ func (c *PushClient) publishInternal(ctx context.Context, messages []PushMessage
 		return nil, NewPushServerError("Invalid server response", resp, r, nil)
 	}
 	// Sanity check the response
+	var mismatch *ResponseLengthMismatchError
 	if expectedReceipts != len(r.Data) {
-		message := "Mismatched response length. Expected %d receipts but only received %d"
-		errorMessage := fmt.Sprintf(message, len(messages), len(r.Data))
-		return nil, NewPushServerError(errorMessage, resp, r, nil)
+		raw := make([]PushResponse, len(r.Data))
+		copy(raw, r.Data)
+		mismatch = &ResponseLengthMismatchError{Expected: expectedReceipts, Received: len(r.Data), Data: raw}
+		// Too many entries can't be attributed to notifications at all
+		if len(r.Data) > expectedReceipts {
+			return nil, mismatch
+		}
 	}
 	// Add the original message to each response for reference
+	timing := trace.result()
 	i := 0
 	for _, msg := range messages {
 		for _, to := range msg.To {
+			if i == len(r.Data) {
+				return r.Data, mismatch
+			}
 			r.Data[i].PushMessage = msg
 			r.Data[i].PushMessage.To = []string{to}
+			r.Data[i].Metadata = msg.Metadata
+			r.Data[i].successStatuses = c.successStatuses
+			r.Data[i].classify = c.classify
+			r.Data[i].SentAt = sentAt
+			r.Data[i].BatchID = b.id
+			r.Data[i].Timing = timing
+			r.Data[i].accessToken = accessToken
 			i += 1
 		}
 	}
 	return r.Data, nil
 }
 
+// wait blocks until the limiter allows n notifications to be sent.
+// Requests larger than the limiter's burst wait for it in burst-sized steps,
+// after any pause requested by X-RateLimit-* headers. An unlimited limiter
+// never paces; a finite one with no burst can never allow a send.
+func (c *PushClient) wait(ctx context.Context, n int) error {
+	if c.limiter == nil {
+		return nil
+	}
+	if err := c.waitPause(ctx); err != nil {
+		return err
+	}
+	if c.limiter.Limit() == rate.Inf {
+		return nil
+	}
+	burst := c.limiter.Burst()
+	if burst <= 0 {
+		return fmt.Errorf("rate limiter burst %d allows no notifications", burst)
+	}
+	for n > burst {
+		if err := c.limiter.WaitN(ctx, burst); err != nil {
+			return err
+		}
+		n -= burst
+	}
+	return c.limiter.WaitN(ctx, n)
+}
+
+// Ping checks that the Expo endpoint is reachable and accepts the client's
+// access token by requesting the receipts of an empty list of IDs.
+// Healthy means the request completed with a 2xx status; a rejected access
+// token is returned as an *InvalidCredentialsError.
+func (c *PushClient) Ping(ctx context.Context) error {
+	body, err := json.Marshal(&receiptsRequest{IDs: []string{}})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.requestContext(ctx, 0)
+	defer cancel()
+	req, err := c.buildRequest(ctx, c.receiptsEndpoint, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// do sends req, reading and decoding the response body so that it can be
+// echoed to the debug writer and so that the request timeout covers it
+func (c *PushClient) do(req *http.Request) (*http.Response, error) {
+	if c.inFlight != nil {
+		select {
+		case c.inFlight <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		defer func() { <-c.inFlight }()
+	}
+	c.debugRequest(req)
+	resp, err := c.client().Do(req)
+	if err != nil {
+		c.debugResponse(nil, nil, err)
+		return nil, err
+	}
+	body, err := decodeBody(resp)
+	resp.Body.Close()
+	if err != nil {
+		c.debugResponse(nil, nil, err)
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	atomic.AddInt64(&c.stats.bytes, req.ContentLength+int64(len(body)))
+	c.observeRateLimit(resp)
+	c.debugResponse(resp, body, nil)
+	return resp, nil
+}
+
+// client returns the HTTP client for the next request, rotating through
+// ClientConfig.HTTPClients if set
+func (c *PushClient) client() *http.Client {
+	if len(c.httpClients) == 0 {
+		return c.httpClient
+	}
+	n := atomic.AddUint64(c.nextClient, 1) - 1
+	return c.httpClients[n%uint64(len(c.httpClients))]
+}
+
+// decodeBody reads the response body, decompressing it according to its
+// Content-Encoding, gzip or deflate. A body which doesn't match its declared
+// encoding, or has an encoding the client can't decode, is returned as a
+// *MalformedResponseError.
+func decodeBody(resp *http.Response) ([]byte, error) {
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	var zr io.Reader
+	switch encoding {
+	case "", "identity":
+		if bytes.HasPrefix(raw, gzipMagic) {
+			return nil, newMalformedResponseError(encoding, raw, errors.New("gzip body without gzip Content-Encoding"))
+		}
+		return raw, nil
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, newMalformedResponseError(encoding, raw, err)
+		}
+		zr = gr
+	case "deflate":
+		zr, err = deflateReader(raw)
+		if err != nil {
+			return nil, newMalformedResponseError(encoding, raw, err)
+		}
+	default:
+		return nil, newMalformedResponseError(encoding, raw, errors.New("unsupported Content-Encoding"))
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, newMalformedResponseError(encoding, raw, err)
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Uncompressed = true
+	return body, nil
+}
+
+// deflateReader decompresses a deflate body, which HTTP defines as zlib
+// wrapped, falling back to raw deflate as sent by some servers
+func deflateReader(raw []byte) (io.Reader, error) {
+	if zr, err := zlib.NewReader(bytes.NewReader(raw)); err == nil {
+		return zr, nil
+	}
+	return flate.NewReader(bytes.NewReader(raw)), nil
+}
+
+// gzipBody compresses a request body
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gzipMagic is the header every gzip stream starts with
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// unauthorizedError builds the error for a 401, noting whether the request
+// carried an access token and including Expo's explanation if there is one
+func unauthorizedError(resp *http.Response) *InvalidCredentialsError {
+	provided := resp.Request != nil && resp.Request.Header.Get("Authorization") != ""
+	message := fmt.Sprintf("No access token provided (%s)", resp.Status)
+	if provided {
+		message = fmt.Sprintf("Access token rejected (%s)", resp.Status)
+	}
+	var r Response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err == nil && len(r.Errors) > 0 && r.Errors[0]["message"] != "" {
+		message += ": " + r.Errors[0]["message"]
+	}
+	return &InvalidCredentialsError{
+		PushResponseError: PushResponseError{
+			Response: &PushResponse{Status: "error", Message: message},
+		},
+		TokenProvided: provided,
+	}
+}
+
+// checkStatus returns an error for non-2xx responses.
+// A 401 is returned as an *InvalidCredentialsError and a 413 as a *PayloadTooLargeError.
 func checkStatus(resp *http.Response) error {
 	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
 		return nil
 	}
-	return fmt.Errorf("Invalid response (%d %s)", resp.StatusCode, resp.Status)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return unauthorizedError(resp)
+	}
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		err := &PayloadTooLargeError{}
+		if resp.Request != nil {
+			err.Size = resp.Request.ContentLength
+		}
+		return err
+	}
+	err := &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
 }
@@ -0,0 +1,2188 @@
+package expo
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// newPushServer returns a server which accepts every notification it receives,
+// counting the requests made to it.
+func newPushServer(t *testing.T, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(requests, 1)
+		acceptAll(t, w, req)
+	}))
+}
+
+// acceptAll responds with an ok response for every notification in req
+func acceptAll(t *testing.T, w http.ResponseWriter, req *http.Request) {
+	var messages []PushMessage
+	if err := json.NewDecoder(req.Body).Decode(&messages); err != nil {
+		t.Error("Invalid request body")
+	}
+	data := []PushResponse{}
+	for _, m := range messages {
+		for range m.To {
+			data = append(data, PushResponse{ID: "receipt", Status: SuccessStatus})
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func TestPublishMultipleEmpty(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("Unexpected request for empty messages")
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	for _, messages := range [][]PushMessage{nil, {}} {
+		responses, err := client.PublishMultiple(context.Background(), messages)
+		if err != nil {
+			t.Error("Errored on empty messages")
+		}
+		if responses == nil || len(responses) != 0 {
+			t.Error("Expected an empty array of responses")
+		}
+	}
+}
+
+func TestSharedLimiterAcrossClients(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+
+	interval := 50 * time.Millisecond
+	limiter := rate.NewLimiter(rate.Every(interval), 1)
+	first := NewPushClient(&ClientConfig{Host: server.URL, Limiter: limiter})
+	second := NewPushClient(&ClientConfig{Host: server.URL, Limiter: limiter})
+
+	message := &PushMessage{To: []string{"ExponentPushToken[xxxxxxxxxxxxxxxxxxxxxx]"}, Body: "hello"}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		for _, client := range []*PushClient{first, second} {
+			if _, err := client.Publish(context.Background(), message); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// The first send uses the burst, the other three each wait an interval
+	if elapsed := time.Since(start); elapsed < 3*interval {
+		t.Errorf("Expected combined pacing of at least %s, took %s", 3*interval, elapsed)
+	}
+	if requests != 4 {
+		t.Errorf("Expected 4 requests, got %d", requests)
+	}
+}
+
+func TestLimiterWithoutBurst(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	unlimited := NewPushClient(&ClientConfig{Host: server.URL, Limiter: rate.NewLimiter(rate.Inf, 0)})
+	if _, err := unlimited.PublishMultiple(ctx, testMessages(2*MaxNotificationsPerRequest)); err != nil {
+		t.Errorf("Expected an unlimited limiter not to pace, got %v", err)
+	}
+
+	blocked := NewPushClient(&ClientConfig{Host: server.URL, Limiter: rate.NewLimiter(10, 0)})
+	_, err := blocked.PublishMultiple(ctx, testMessages(1))
+	if err == nil || !strings.Contains(err.Error(), "burst") || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a finite limiter without burst to fail fast, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected only the unlimited client's 2 requests, got %d", requests)
+	}
+}
+
+func TestSharedLimiterSizing(t *testing.T) {
+	limiter := SharedLimiter()
+	if limiter.Limit() != NotificationsPerSecond || limiter.Burst() != NotificationsPerSecond {
+		t.Error("Shared limiter isn't sized to Expo's documented limit")
+	}
+}
+
+func TestValidateStrictControlCharacters(t *testing.T) {
+	token := "ExponentPushToken[xxxxxxxxxxxxxxxxxxxxxx]"
+	cases := []struct {
+		message PushMessage
+		field   string
+	}{
+		{PushMessage{To: []string{token}, Title: "Hello\nworld"}, "Title"},
+		{PushMessage{To: []string{token}, Subtitle: "tab\there"}, "Subtitle"},
+		{PushMessage{To: []string{token}, Body: "bell\a"}, "Body"},
+		{PushMessage{To: []string{token}, Title: "Hello", Body: "multi\nline"}, ""},
+	}
+	strict := NewPushClient(&ClientConfig{Strict: true})
+	lenient := NewPushClient(nil)
+	for _, c := range cases {
+		messages := []PushMessage{{To: []string{token}}, c.message}
+		_, err := strict.validate(messages)
+		if c.field == "" {
+			if err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+			continue
+		}
+		typed, ok := err.(*ValidationError)
+		if !ok {
+			t.Fatalf("Incorrect error type for %s", c.field)
+		}
+		if typed.Field != c.field || typed.Index != 1 || !errors.Is(err, ErrControlCharacter) {
+			t.Errorf("Expected control character error on %s, got %v", c.field, err)
+		}
+		if _, err := lenient.validate(messages); err != nil {
+			t.Error("Validated content without strict mode")
+		}
+	}
+}
+
+// testMessages returns n single-recipient messages
+func testMessages(n int) []PushMessage {
+	messages := make([]PushMessage, n)
+	for i := range messages {
+		messages[i] = PushMessage{
+			To:   []string{fmt.Sprintf("ExponentPushToken[%d]", i)},
+			Body: fmt.Sprintf("message %d", i),
+		}
+	}
+	return messages
+}
+
+func TestMarshalBatch(t *testing.T) {
+	client := NewPushClient(nil)
+	messages := testMessages(MaxNotificationsPerRequest + 50)
+	bodies, err := client.MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d", len(bodies))
+	}
+	for i, expected := range [][]PushMessage{messages[:MaxNotificationsPerRequest], messages[MaxNotificationsPerRequest:]} {
+		b, _ := json.Marshal(expected)
+		if !bytes.Equal(bodies[i], b) {
+			t.Errorf("Chunk %d body doesn't match expected messages", i)
+		}
+	}
+}
+
+func TestStringifyData(t *testing.T) {
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}, Body: "hi", Data: map[string]interface{}{"id": 7}},
+		{To: []string{"ExponentPushToken[b]"}, Body: "no data"},
+	}
+	object, err := NewPushClient(nil).MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stringified, err := NewPushClient(&ClientConfig{StringifyData: true}).MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []map[string]interface{}
+	json.Unmarshal(object[0], &decoded)
+	if data, ok := decoded[0]["data"].(map[string]interface{}); !ok || data["id"] != float64(7) {
+		t.Errorf("Expected data as an object by default, got %v", decoded[0]["data"])
+	}
+	decoded = nil
+	json.Unmarshal(stringified[0], &decoded)
+	if decoded[0]["data"] != `{"id":7}` {
+		t.Errorf("Expected data as a JSON string, got %v", decoded[0]["data"])
+	}
+	if _, ok := decoded[1]["data"]; ok {
+		t.Error("Expected nil data to be omitted")
+	}
+	if decoded[0]["body"] != "hi" || decoded[0]["to"] == nil {
+		t.Error("Expected the other fields to be kept")
+	}
+}
+
+func TestBatchMarshaler(t *testing.T) {
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewDecoder(req.Body).Decode(&received)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": []PushResponse{{Status: SuccessStatus}}})
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{
+		Host: server.URL,
+		BatchMarshaler: func(messages []PushMessage) ([]byte, error) {
+			remapped := make([]map[string]interface{}, len(messages))
+			for i, m := range messages {
+				remapped[i] = map[string]interface{}{"to": m.To, "message": m.Body}
+			}
+			return json.Marshal(remapped)
+		},
+	})
+
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || received[0]["message"] != "message 0" {
+		t.Fatalf("Expected the remapped field, got %v", received)
+	}
+	if _, ok := received[0]["body"]; ok {
+		t.Error("Expected the standard field name to be replaced")
+	}
+}
+
+func TestChunkMessagesCountsRecipients(t *testing.T) {
+	messages := []PushMessage{
+		{To: make([]string, 60)},
+		{To: make([]string, 40)},
+		{To: make([]string, 1)},
+		{To: make([]string, 150)},
+		{To: make([]string, 1)},
+	}
+	chunks := ChunkMessages(messages, MaxNotificationsPerRequest)
+	expected := []int{2, 1, 1, 1}
+	if len(chunks) != len(expected) {
+		t.Fatalf("Expected %d chunks, got %d", len(expected), len(chunks))
+	}
+	for i, n := range expected {
+		if len(chunks[i]) != n {
+			t.Errorf("Expected %d messages in chunk %d, got %d", n, i, len(chunks[i]))
+		}
+	}
+}
+
+func TestChunkMessagesSizes(t *testing.T) {
+	cases := []struct {
+		messages, size int
+		expected       []int
+	}{
+		{6, 3, []int{3, 3}},
+		{7, 3, []int{3, 3, 1}},
+		{0, 3, nil},
+		{MaxNotificationsPerRequest + 1, 0, []int{MaxNotificationsPerRequest, 1}},
+		{2, -1, []int{2}},
+	}
+	for _, tc := range cases {
+		chunks := ChunkMessages(testMessages(tc.messages), tc.size)
+		if len(chunks) != len(tc.expected) {
+			t.Errorf("%d messages by %d: expected %d chunks, got %d", tc.messages, tc.size, len(tc.expected), len(chunks))
+			continue
+		}
+		for i, n := range tc.expected {
+			if len(chunks[i]) != n {
+				t.Errorf("%d messages by %d: expected %d messages in chunk %d, got %d", tc.messages, tc.size, n, i, len(chunks[i]))
+			}
+		}
+	}
+}
+
+func TestPartitionByProject(t *testing.T) {
+	projects := map[string]string{
+		"ExponentPushToken[a1]": "@acme/a", "ExponentPushToken[a2]": "@acme/a",
+		"ExponentPushToken[b1]": "@acme/b", "ExponentPushToken[b2]": "@acme/b",
+	}
+	projectOf := func(token string) string { return projects[token] }
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a1]", "ExponentPushToken[b1]", "ExponentPushToken[x]"}, Body: "mixed"},
+		{To: []string{"ExponentPushToken[b2]"}, Body: "b"},
+		{To: []string{"ExponentPushToken[a2]"}, Body: "a"},
+	}
+	groups := partitionByProject(messages, projectOf)
+	expected := [][]string{
+		{"ExponentPushToken[a1]", "ExponentPushToken[a2]"},
+		{"ExponentPushToken[b1]", "ExponentPushToken[b2]"},
+		{"ExponentPushToken[x]"},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("Expected %d groups, got %d", len(expected), len(groups))
+	}
+	for i, group := range groups {
+		var tokens []string
+		for _, m := range group {
+			tokens = append(tokens, m.To...)
+		}
+		if !reflect.DeepEqual(tokens, expected[i]) {
+			t.Errorf("Group %d: expected %v, got %v", i, expected[i], tokens)
+		}
+	}
+	if groups[0][0].Body != "mixed" || groups[0][1].Body != "a" {
+		t.Error("Expected split messages to keep their fields and order")
+	}
+	if len(messages[0].To) != 3 {
+		t.Error("Expected the caller's messages to be left unchanged")
+	}
+
+	client := NewPushClient(&ClientConfig{ProjectOf: projectOf, ChunkSize: 1})
+	if chunks, _ := client.PlanRequests(messages); chunks != 5 {
+		t.Errorf("Expected chunking within each project, got %d chunks", chunks)
+	}
+	if chunks, _ := NewPushClient(nil).PlanRequests(messages); chunks != 1 {
+		t.Errorf("Expected no partitioning without ProjectOf, got %d chunks", chunks)
+	}
+}
+
+func TestChunkIndex(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, Concurrency: 3})
+
+	messages := testMessages(2*MaxNotificationsPerRequest + 1)
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies, err := client.MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range responses {
+		if expected := i / MaxNotificationsPerRequest; r.ChunkIndex != expected {
+			t.Fatalf("Response %d: expected chunk %d, got %d", i, expected, r.ChunkIndex)
+		}
+		if !bytes.Contains(bodies[r.ChunkIndex], []byte(r.PushMessage.To[0])) {
+			t.Fatalf("Response %d: token missing from the body of chunk %d", i, r.ChunkIndex)
+		}
+	}
+
+	responses, err = client.PublishMultiple(context.Background(), testMessages(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range responses {
+		if r.ChunkIndex != 0 {
+			t.Errorf("Expected chunk 0 for a single chunk send, got %d", r.ChunkIndex)
+		}
+	}
+}
+
+func TestPublishValid(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	messages := testMessages(5)
+	messages[1].To = []string{"not-a-token"}
+	messages[3].To = nil
+	responses, invalid, err := client.PublishValid(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 3 || requests != 1 {
+		t.Fatalf("Expected the 3 valid messages sent in 1 request, got %d in %d", len(responses), requests)
+	}
+	for i, index := range []int{0, 2, 4} {
+		if responses[i].PushMessage.To[0] != messages[index].To[0] {
+			t.Errorf("Response %d: expected message %d, got %v", i, index, responses[i].PushMessage.To)
+		}
+	}
+	var first, second *ValidationError
+	if len(invalid) != 2 || !errors.As(invalid[0], &first) || !errors.As(invalid[1], &second) {
+		t.Fatalf("Expected 2 validation errors, got %v", invalid)
+	}
+	if first.Index != 1 || !errors.Is(first, ErrMalformedToken) || second.Index != 3 || !errors.Is(second, ErrNoRecipients) {
+		t.Errorf("Expected errors for messages 1 and 3, got %v", invalid)
+	}
+}
+
+func TestPublishMultipleChunks(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	messages := testMessages(2*MaxNotificationsPerRequest + 1)
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+	if len(responses) != len(messages) {
+		t.Fatalf("Expected %d responses, got %d", len(messages), len(responses))
+	}
+	for i, r := range responses {
+		if r.PushMessage.To[0] != messages[i].To[0] {
+			t.Fatalf("Response %d isn't mapped to its message", i)
+		}
+	}
+}
+
+func TestChunkSize(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+
+	bodies, err := NewPushClient(nil).MarshalBatch(testMessages(MaxNotificationsPerRequest + 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Errorf("Expected chunks of MaxNotificationsPerRequest, got %d chunks", len(bodies))
+	}
+
+	client := NewPushClient(&ClientConfig{Host: server.URL, ChunkSize: 10})
+	responses, err := client.PublishMultiple(context.Background(), testMessages(25))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 || len(responses) != 25 {
+		t.Errorf("Expected 3 requests and 25 responses, got %d and %d", requests, len(responses))
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	broadcast := testMessages(53)
+	for _, m := range broadcast[1:] {
+		broadcast[0].To = append(broadcast[0].To, m.To...)
+	}
+	responses, err = client.PublishMultiple(context.Background(), broadcast[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 6 || len(responses) != 53 || responses[52].PushMessage.To[0] != "ExponentPushToken[52]" {
+		t.Errorf("Expected a multi-recipient message to be split into 6 requests of ChunkSize, got %d requests and %d responses", requests, len(responses))
+	}
+
+	client = NewPushClient(&ClientConfig{ChunkSize: 2 * MaxNotificationsPerRequest})
+	bodies, err = client.MarshalBatch(testMessages(2 * MaxNotificationsPerRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Errorf("Expected ChunkSize to be capped at MaxNotificationsPerRequest, got %d chunks", len(bodies))
+	}
+}
+
+// fakeClock is a Clock whose timers fire immediately, advancing its time and
+// recording each requested wait.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	delays []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	f.delays = append(f.delays, d)
+	ch := make(chan time.Time, 1)
+	ch <- f.now
+	return ch
+}
+
+func TestMetadataPreservedButNotSent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if bytes.Contains(body, []byte("campaign")) {
+			t.Error("Metadata was sent to the server")
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []PushResponse{{Status: SuccessStatus}, {Status: SuccessStatus}},
+		})
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	responses, err := client.Publish(context.Background(), &PushMessage{
+		To:       []string{"ExponentPushToken[a]", "ExponentPushToken[b]"},
+		Body:     "hello",
+		Metadata: map[string]string{"campaign": "launch"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range responses {
+		if r.Metadata["campaign"] != "launch" {
+			t.Error("Metadata wasn't copied to the response")
+		}
+	}
+}
+
+func TestReplayFailedChunk(t *testing.T) {
+	var requests int32
+	server := newFlakyServer(t, 1, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	messages := testMessages(3)
+	_, err := client.PublishMultiple(context.Background(), messages)
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("Expected a chunk error, got %v", err)
+	}
+	blob, err := client.SerializeBatch(chunkErr.Messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses, err := client.ReplayBatch(context.Background(), blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != len(messages) {
+		t.Fatalf("Expected %d responses, got %d", len(messages), len(responses))
+	}
+	for i, r := range responses {
+		if r.PushMessage.Body != messages[i].Body {
+			t.Errorf("Replayed message %d doesn't match the original", i)
+		}
+	}
+}
+
+func TestReplayStringifiedData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var messages []struct {
+			To   []string `json:"to"`
+			Data string   `json:"data"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&messages); err != nil {
+			t.Errorf("Expected the replay to send Data as a string: %v", err)
+		}
+		data := []PushResponse{}
+		for _, m := range messages {
+			for range m.To {
+				data = append(data, PushResponse{ID: "receipt", Status: SuccessStatus})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, StringifyData: true})
+
+	messages := testMessages(2)
+	messages[0].Data = map[string]interface{}{"id": "42"}
+	blob, err := client.SerializeBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses, err := client.ReplayBatch(context.Background(), blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != len(messages) || responses[0].PushMessage.Data["id"] != "42" {
+		t.Errorf("Expected the replayed messages to keep their Data, got %+v", responses)
+	}
+}
+
+func TestValidateStrictExpiration(t *testing.T) {
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{Strict: true, Clock: clock})
+	token := "ExponentPushToken[xxxxxxxxxxxxxxxxxxxxxx]"
+
+	past := []PushMessage{{To: []string{token}, Expiration: clock.Now().Add(-time.Hour).Unix()}}
+	_, err := client.validate(past)
+	typed, ok := err.(*ValidationError)
+	if !ok || typed.Field != "Expiration" || typed.Index != 0 || !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected an expiration error, got %v", err)
+	}
+	if _, err := NewPushClient(&ClientConfig{Clock: clock}).validate(past); err != nil {
+		t.Error("Validated expiration without strict mode")
+	}
+
+	future := []PushMessage{{To: []string{token}, Expiration: clock.Now().Add(time.Hour).Unix()}}
+	if _, err := client.validate(future); err != nil {
+		t.Errorf("Unexpected error for a future expiration: %v", err)
+	}
+}
+
+func TestNormalizePriorityOnSend(t *testing.T) {
+	messages := []PushMessage{{To: []string{"ExponentPushToken[a]"}, Priority: "High"}}
+	bodies, err := NewPushClient(&ClientConfig{NormalizePriority: true}).MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(bodies[0], []byte(`"priority":"high"`)) {
+		t.Errorf("Expected normalized priority in %s", bodies[0])
+	}
+	if messages[0].Priority != "High" {
+		t.Error("Modified the caller's message")
+	}
+	bodies, _ = NewPushClient(nil).MarshalBatch(messages)
+	if !bytes.Contains(bodies[0], []byte(`"priority":"High"`)) {
+		t.Error("Normalized priority without the option")
+	}
+}
+
+func TestPing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/--/api/v2/push/getReceipts" {
+			t.Errorf("Unexpected path %s", req.URL.Path)
+		}
+		if req.Header.Get("Authorization") != "Bearer valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data": {}}`))
+	}))
+	defer server.Close()
+
+	healthy := NewPushClient(&ClientConfig{Host: server.URL, AccessToken: "valid"})
+	if err := healthy.Ping(context.Background()); err != nil {
+		t.Errorf("Expected a healthy ping, got %v", err)
+	}
+	rejected := NewPushClient(&ClientConfig{Host: server.URL, AccessToken: "revoked"})
+	err := rejected.Ping(context.Background())
+	if _, ok := err.(*InvalidCredentialsError); !ok {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+}
+
+func TestValidateAllCollectsEveryError(t *testing.T) {
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}},
+		{},
+		{To: []string{"ExponentPushToken[b]", "bad", "worse"}},
+	}
+	errs := ValidateAll(messages)
+	expected := []struct {
+		index int
+		field string
+		err   error
+	}{
+		{1, "To", ErrNoRecipients},
+		{2, "To[1]", ErrMalformedToken},
+		{2, "To[2]", ErrMalformedToken},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+	for i, e := range expected {
+		typed, ok := errs[i].(*ValidationError)
+		if !ok || typed.Index != e.index || typed.Field != e.field || !errors.Is(typed, e.err) {
+			t.Errorf("Unexpected error %d: %v", i, errs[i])
+		}
+	}
+	if errs := ValidateAll(messages[:1]); len(errs) != 0 {
+		t.Errorf("Unexpected errors for a valid batch: %v", errs)
+	}
+}
+
+func TestValidateAllStrict(t *testing.T) {
+	client := NewPushClient(&ClientConfig{Strict: true, Clock: newFakeClock()})
+	messages := []PushMessage{
+		{Title: "bad\ttitle", Expiration: 1},
+	}
+	errs := client.ValidateAll(messages)
+	if len(errs) != 3 {
+		t.Fatalf("Expected recipient, content and expiration errors, got %v", errs)
+	}
+	if !errors.Is(errs[1], ErrControlCharacter) || !errors.Is(errs[2], ErrExpired) {
+		t.Errorf("Unexpected strict errors %v", errs)
+	}
+}
+
+func TestRequireDisplayText(t *testing.T) {
+	client := NewPushClient(&ClientConfig{RequireDisplayText: true})
+	silent := PushMessage{To: []string{"ExponentPushToken[a]"}, ContentAvailable: true, Data: map[string]interface{}{"sync": true}}
+	if _, err := client.MarshalBatch([]PushMessage{silent}); err != nil {
+		t.Errorf("Expected a silent push to be allowed, got %v", err)
+	}
+
+	empty := PushMessage{To: []string{"ExponentPushToken[a]"}, Sound: "default"}
+	if _, err := client.MarshalBatch([]PushMessage{empty}); !errors.Is(err, ErrNoDisplayText) {
+		t.Errorf("Expected an empty non-silent push to be flagged, got %v", err)
+	}
+	errs := client.ValidateAll([]PushMessage{silent, empty})
+	var validationErr *ValidationError
+	if len(errs) != 1 || !errors.As(errs[0], &validationErr) || validationErr.Index != 1 {
+		t.Errorf("Expected a single error for the empty message, got %v", errs)
+	}
+	if _, err := NewPushClient(nil).MarshalBatch([]PushMessage{empty}); err != nil {
+		t.Errorf("Expected the check to be opt-in, got %v", err)
+	}
+}
+
+func TestRecipientWhitespace(t *testing.T) {
+	client := NewPushClient(nil)
+	messages := []PushMessage{{To: []string{" ExponentPushToken[a]\n", "ExponentPushToken[b]"}}}
+	bodies, err := client.MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bodies[0]), `"to":["ExponentPushToken[a]","ExponentPushToken[b]"]`) {
+		t.Errorf("Expected the recipients to be sent trimmed, got %s", bodies[0])
+	}
+	if messages[0].To[0] != " ExponentPushToken[a]\n" {
+		t.Error("Expected the caller's messages to be left unchanged")
+	}
+	if errs := client.ValidateAll(messages); len(errs) != 0 {
+		t.Errorf("Expected padded recipients to be valid, got %v", errs)
+	}
+
+	messages[0].To[1] = "ExponentPushToken[b c]"
+	if _, err := client.MarshalBatch(messages); !errors.Is(err, ErrTokenWhitespace) {
+		t.Errorf("Expected interior whitespace to be rejected, got %v", err)
+	}
+	if errs := client.ValidateAll(messages); len(errs) != 1 || !errors.Is(errs[0], ErrTokenWhitespace) {
+		t.Errorf("Expected a single whitespace error, got %v", errs)
+	}
+}
+
+func TestEnforceMaxTTL(t *testing.T) {
+	client := NewPushClient(&ClientConfig{EnforceMaxTTL: true})
+	message := PushMessage{To: []string{"ExponentPushToken[a]"}, TTLSeconds: Int(MaxTTLSeconds)}
+	if _, err := client.MarshalBatch([]PushMessage{message}); err != nil {
+		t.Errorf("Expected the maximum TTL to be allowed, got %v", err)
+	}
+
+	message.TTLSeconds = Int(MaxTTLSeconds + 1)
+	_, err := client.MarshalBatch([]PushMessage{message})
+	var ttlErr *TTLTooLongError
+	if !errors.As(err, &ttlErr) || ttlErr.TTLSeconds != MaxTTLSeconds+1 || ttlErr.Max != MaxTTLSeconds {
+		t.Errorf("Expected a *TTLTooLongError, got %v", err)
+	}
+	if errs := client.ValidateAll([]PushMessage{message}); len(errs) != 1 || !errors.As(errs[0], &ttlErr) {
+		t.Errorf("Expected ValidateAll to report the TTL, got %v", errs)
+	}
+	if _, err := NewPushClient(nil).MarshalBatch([]PushMessage{message}); err != nil {
+		t.Errorf("Expected the check to be opt-in, got %v", err)
+	}
+}
+
+func TestValidateCollapseID(t *testing.T) {
+	client := NewPushClient(nil)
+	message := PushMessage{
+		To:                 []string{"ExponentPushToken[a]"},
+		APNSCollapseID:     strings.Repeat("a", MaxAPNSCollapseIDBytes),
+		AndroidCollapseKey: strings.Repeat("b", 4*MaxAPNSCollapseIDBytes),
+	}
+	if _, err := client.MarshalBatch([]PushMessage{message}); err != nil {
+		t.Errorf("Expected collapse identifiers within the limits to be valid, got %v", err)
+	}
+	message.APNSCollapseID += "a"
+	if _, err := client.MarshalBatch([]PushMessage{message}); !errors.Is(err, ErrCollapseIDTooLong) {
+		t.Errorf("Expected a long APNs collapse ID to be rejected, got %v", err)
+	}
+	var validationErr *ValidationError
+	if errs := client.ValidateAll([]PushMessage{message}); len(errs) != 1 || !errors.As(errs[0], &validationErr) || validationErr.Field != "APNSCollapseID" {
+		t.Errorf("Expected a single APNSCollapseID error, got %v", errs)
+	}
+}
+
+func TestKnownCategories(t *testing.T) {
+	client := NewPushClient(&ClientConfig{Strict: true, KnownCategories: []string{"reply", "invite"}})
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}, CategoryID: "reply"},
+		{To: []string{"ExponentPushToken[b]"}},
+	}
+	if _, err := client.MarshalBatch(messages); err != nil {
+		t.Errorf("Expected known and unset categories to be valid, got %v", err)
+	}
+
+	messages = append(messages, PushMessage{To: []string{"ExponentPushToken[c]"}, CategoryID: "repyl"})
+	_, err := client.MarshalBatch(messages)
+	var categoryErr *UnknownCategoryError
+	if !errors.As(err, &categoryErr) || categoryErr.CategoryID != "repyl" {
+		t.Errorf("Expected an *UnknownCategoryError naming the category, got %v", err)
+	}
+	if errs := client.ValidateAll(messages); len(errs) != 1 || !strings.Contains(errs[0].Error(), `"repyl"`) {
+		t.Errorf("Expected a single error naming the category, got %v", errs)
+	}
+	if _, err := NewPushClient(&ClientConfig{KnownCategories: []string{"reply"}}).MarshalBatch(messages); err != nil {
+		t.Errorf("Expected the check to apply only in strict mode, got %v", err)
+	}
+}
+
+func TestValidateStrictBackgroundAlert(t *testing.T) {
+	client := NewPushClient(&ClientConfig{Strict: true})
+	background := PushMessage{To: []string{"ExponentPushToken[a]"}, ContentAvailable: true}
+	if _, err := client.MarshalBatch([]PushMessage{background}); err != nil {
+		t.Errorf("Expected a background push to be valid, got %v", err)
+	}
+	withTitle, withSound, withBadge := background, background, background
+	withTitle.Title = "hello"
+	withSound.Sound = "default"
+	withBadge.Badge = Int(0)
+	for name, message := range map[string]PushMessage{"title": withTitle, "sound": withSound, "badge": withBadge} {
+		if message.APNSPushType() != APNSPushTypeAlert {
+			t.Fatalf("Expected a background push with a %s to be sent as an alert", name)
+		}
+		if _, err := client.MarshalBatch([]PushMessage{message}); !errors.Is(err, ErrBackgroundAlert) {
+			t.Errorf("Expected a background push with a %s to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestDefaultTTLSeconds(t *testing.T) {
+	client := NewPushClient(&ClientConfig{DefaultTTLSeconds: 600})
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}},
+		{To: []string{"ExponentPushToken[b]"}, TTLSeconds: Int(0)},
+		{To: []string{"ExponentPushToken[c]"}, TTLSeconds: Int(60)},
+		{To: []string{"ExponentPushToken[d]"}, Expiration: 42},
+	}
+	bodies, err := client.MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []PushMessage
+	if err := json.Unmarshal(bodies[0], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent[0].TTLSeconds == nil || *sent[0].TTLSeconds != 600 {
+		t.Errorf("Expected the default TTL on an unset message, got %v", sent[0].TTLSeconds)
+	}
+	if *sent[1].TTLSeconds != 0 || *sent[2].TTLSeconds != 60 {
+		t.Errorf("Expected explicit TTLs to be kept, got %d and %d", *sent[1].TTLSeconds, *sent[2].TTLSeconds)
+	}
+	if sent[3].TTLSeconds != nil {
+		t.Errorf("Expected no default TTL with an expiration, got %d", *sent[3].TTLSeconds)
+	}
+	if messages[0].TTLSeconds != nil {
+		t.Error("Expected the caller's messages to be left unchanged")
+	}
+}
+
+func TestDefaultPriorityConfig(t *testing.T) {
+	client := NewPushClient(&ClientConfig{DefaultPriority: HighPriority})
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}},
+		{To: []string{"ExponentPushToken[b]"}, Priority: NormalPriority},
+	}
+	bodies, err := client.MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []PushMessage
+	if err := json.Unmarshal(bodies[0], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent[0].Priority != HighPriority || sent[1].Priority != NormalPriority {
+		t.Errorf("Expected the default only for the unset priority, got %q and %q", sent[0].Priority, sent[1].Priority)
+	}
+	if messages[0].Priority != "" {
+		t.Error("Expected the caller's messages to be left unchanged")
+	}
+
+	invalid := &ClientConfig{DefaultPriority: "urgent"}
+	if err := invalid.Validate(); !errors.Is(err, ErrInvalidDefaultPriority) || !strings.Contains(err.Error(), `"urgent"`) {
+		t.Errorf("Expected Validate to reject the invalid default, got %v", err)
+	}
+	if err := (&ClientConfig{DefaultPriority: NormalPriority}).Validate(); err != nil {
+		t.Errorf("Expected a valid default to pass, got %v", err)
+	}
+	if priority := NewPushClient(invalid).defaultPriority; priority != "" {
+		t.Errorf("Expected NewPushClient to ignore the invalid default, got %q", priority)
+	}
+}
+
+func TestTTLAsExpiration(t *testing.T) {
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{Clock: clock, TTLAsExpiration: true})
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]"}, TTLSeconds: Int(3600)},
+		{To: []string{"ExponentPushToken[b]"}, TTLSeconds: Int(60), Expiration: 42},
+		{To: []string{"ExponentPushToken[c]"}},
+	}
+	bodies, err := client.MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []map[string]interface{}
+	if err := json.Unmarshal(bodies[0], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if expected := float64(clock.Now().Unix() + 3600); sent[0]["expiration"] != expected {
+		t.Errorf("Expected expiration %v, got %v", expected, sent[0]["expiration"])
+	}
+	if _, ok := sent[0]["ttl"]; ok {
+		t.Errorf("Expected the TTL to be cleared, got %v", sent[0])
+	}
+	if sent[1]["expiration"] != float64(42) || sent[1]["ttl"] != float64(60) {
+		t.Errorf("Expected an explicit expiration to be kept, got %v", sent[1])
+	}
+	if _, ok := sent[2]["expiration"]; ok {
+		t.Errorf("Expected no expiration without a TTL, got %v", sent[2])
+	}
+	if messages[0].TTLSeconds == nil || messages[0].Expiration != 0 {
+		t.Error("Expected the caller's messages to be left unchanged")
+	}
+}
+
+func TestMessageTransformer(t *testing.T) {
+	var sent []PushMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		json.Unmarshal(body, &sent)
+		acceptAll(t, w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{
+		Host: server.URL,
+		MessageTransformer: func(m PushMessage) PushMessage {
+			m.ChannelID = "default-channel"
+			data := map[string]interface{}{"source": "sdk"}
+			for k, v := range m.Data {
+				data[k] = v
+			}
+			m.Data = data
+			return m
+		},
+	})
+
+	messages := []PushMessage{{To: []string{"ExponentPushToken[a]"}, Data: map[string]interface{}{"id": "1"}}}
+	if _, err := client.PublishMultiple(context.Background(), messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].ChannelID != "default-channel" || sent[0].Data["source"] != "sdk" || sent[0].Data["id"] != "1" {
+		t.Errorf("Transformed fields didn't reach the wire: %+v", sent)
+	}
+	if messages[0].ChannelID != "" {
+		t.Error("Modified the caller's message")
+	}
+}
+
+func TestTransformerEmptiesRecipients(t *testing.T) {
+	var requests int32
+	// Like Expo, reject any request containing a message without recipients
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, _ := io.ReadAll(req.Body)
+		var messages []PushMessage
+		json.Unmarshal(body, &messages)
+		for _, m := range messages {
+			if len(m.To) == 0 {
+				w.Write([]byte(`{"errors": [{"code": "VALIDATION_ERROR", "message": "\"to\" is required"}]}`))
+				return
+			}
+		}
+		acceptAll(t, w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	}))
+	defer server.Close()
+	suppressed := map[string]bool{"ExponentPushToken[1]": true, "ExponentPushToken[3]": true}
+	client := NewPushClient(&ClientConfig{
+		Host: server.URL,
+		MessageTransformer: func(m PushMessage) PushMessage {
+			var to []string
+			for _, token := range m.To {
+				if !suppressed[token] {
+					to = append(to, token)
+				}
+			}
+			m.To = to
+			return m
+		},
+	})
+
+	messages := testMessages(4)
+	messages[0].To = append(messages[0].To, "ExponentPushToken[3]")
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ExponentPushToken[0]", "ExponentPushToken[2]"}
+	if len(responses) != len(expected) {
+		t.Fatalf("Expected %d responses, got %d", len(expected), len(responses))
+	}
+	for i, token := range expected {
+		if responses[i].PushMessage.To[0] != token {
+			t.Errorf("Response %d is mapped to %s, expected %s", i, responses[i].PushMessage.To[0], token)
+		}
+	}
+
+	requests = 0
+	responses, err = client.PublishMultiple(context.Background(), []PushMessage{messages[1]})
+	if err != nil || len(responses) != 0 || requests != 0 {
+		t.Errorf("Expected nothing to be sent, got %d responses, %d requests and %v", len(responses), requests, err)
+	}
+}
+
+// countingTransport counts the requests passing through it
+type countingTransport struct {
+	requests int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTransport(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	transport := &countingTransport{}
+	client := NewPushClient(&ClientConfig{Host: server.URL, Transport: transport})
+
+	if _, err := client.PublishMultiple(context.Background(), testMessages(MaxNotificationsPerRequest+1)); err != nil {
+		t.Fatal(err)
+	}
+	if transport.requests != 2 {
+		t.Errorf("Expected 2 requests through the transport, got %d", transport.requests)
+	}
+
+	ignored := &countingTransport{}
+	client = NewPushClient(&ClientConfig{Host: server.URL, HTTPClient: &http.Client{}, Transport: ignored})
+	client.PublishMultiple(context.Background(), testMessages(1))
+	if ignored.requests != 0 {
+		t.Error("Expected the transport to be ignored when an HTTPClient is given")
+	}
+}
+
+func TestPublishOversizedRecipients(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	to := make([]string, 5*MaxRecipientsPerMessage)
+	for i := range to {
+		to[i] = fmt.Sprintf("ExponentPushToken[%d]", i)
+	}
+	responses, err := client.Publish(context.Background(), &PushMessage{To: to, Body: "broadcast"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != len(to) {
+		t.Fatalf("Expected %d responses, got %d", len(to), len(responses))
+	}
+	for i, r := range responses {
+		if r.PushMessage.To[0] != to[i] {
+			t.Fatalf("Response %d isn't mapped to its token", i)
+		}
+	}
+	if requests != 5 {
+		t.Errorf("Expected 5 requests, got %d", requests)
+	}
+}
+
+func TestDebugOutputRedacted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"data": [{"status": "error", "message": "\"ExponentPushToken[secret]\" is not a registered push notification recipient"}]}`))
+	}))
+	defer server.Close()
+	var debug bytes.Buffer
+	client := NewPushClient(&ClientConfig{Host: server.URL, AccessToken: "access-secret", Debug: &debug})
+
+	responses, err := client.Publish(context.Background(), &PushMessage{To: []string{"ExponentPushToken[secret]"}, Body: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].PushMessage.To[0] != "ExponentPushToken[secret]" {
+		t.Error("Response wasn't decoded after being echoed")
+	}
+	out := debug.String()
+	if strings.Contains(out, "secret") {
+		t.Errorf("Debug output wasn't redacted:\n%s", out)
+	}
+	for _, expected := range []string{"> POST " + server.URL + "/--/api/v2/push/send", "> Authorization: [REDACTED]", `"body":"hello"`, "< 200 OK", "is not a registered"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in debug output:\n%s", expected, out)
+		}
+	}
+}
+
+func TestDebugAndLoggerSerialized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	// One buffer, unsafe for concurrent use, behind both
+	var out bytes.Buffer
+	client := NewPushClient(&ClientConfig{
+		Host:        server.URL,
+		Debug:       &out,
+		Logger:      log.New(&out, "", 0),
+		Concurrency: 4,
+		ChunkSize:   1,
+	})
+
+	client.PublishMultiple(context.Background(), testMessages(8))
+	if n := strings.Count(out.String(), "< 500 Internal Server Error"); n != 8 {
+		t.Errorf("Expected a debug line per request, got %d:\n%s", n, out.String())
+	}
+	if n := strings.Count(out.String(), "failed"); n != 8 {
+		t.Errorf("Expected a log line per failed chunk, got %d", n)
+	}
+}
+
+func TestCustomSuccessStatuses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"data": [{"status": "queued"}, {"status": "ok"}, {"status": "error"}]}`))
+	}))
+	defer server.Close()
+	messages := []PushMessage{{To: []string{"ExponentPushToken[a]", "ExponentPushToken[b]", "ExponentPushToken[c]"}}}
+
+	strict := NewPushClient(&ClientConfig{Host: server.URL})
+	responses, err := strict.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].ValidateResponse() == nil {
+		t.Error("Expected queued to be an error by default")
+	}
+
+	relay := NewPushClient(&ClientConfig{Host: server.URL, SuccessStatuses: []string{"ok", "queued"}})
+	responses, err = relay.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].ValidateResponse() != nil || responses[1].ValidateResponse() != nil {
+		t.Error("Expected configured statuses to count as success")
+	}
+	if responses[2].ValidateResponse() == nil {
+		t.Error("Expected error status to still fail")
+	}
+}
+
+func TestEndpointURLs(t *testing.T) {
+	cases := []struct {
+		config   *ClientConfig
+		send     string
+		receipts string
+	}{
+		{nil, "https://exp.host/--/api/v2/push/send", "https://exp.host/--/api/v2/push/getReceipts"},
+		{&ClientConfig{Host: "http://localhost:8080"}, "http://localhost:8080/--/api/v2/push/send", "http://localhost:8080/--/api/v2/push/getReceipts"},
+		{&ClientConfig{Host: "https://relay.example.com", APIURL: "/expo"}, "https://relay.example.com/expo/push/send", "https://relay.example.com/expo/push/getReceipts"},
+	}
+	for _, c := range cases {
+		client := NewPushClient(c.config)
+		if client.SendURL() != c.send {
+			t.Errorf("Expected send URL %s, got %s", c.send, client.SendURL())
+		}
+		if client.ReceiptsURL() != c.receipts {
+			t.Errorf("Expected receipts URL %s, got %s", c.receipts, client.ReceiptsURL())
+		}
+	}
+}
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func deflateBytes(b []byte, wrapped bool) []byte {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	if wrapped {
+		zw = zlib.NewWriter(&buf)
+	} else {
+		zw, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestResponseEncoding(t *testing.T) {
+	body := []byte(`{"data": [{"status": "ok", "id": "receipt"}]}`)
+	cases := []struct {
+		name      string
+		encoding  string
+		body      []byte
+		malformed bool
+	}{
+		{"gzip", "gzip", gzipBytes(body), false},
+		{"deflate", "deflate", deflateBytes(body, true), false},
+		{"raw deflate", "deflate", deflateBytes(body, false), false},
+		{"identity", "", body, false},
+		{"explicit identity", "identity", body, false},
+		{"unknown", "br", body, true},
+		{"mislabeled deflate", "deflate", body, true},
+		{"mislabeled gzip", "gzip", body, true},
+		{"unlabeled gzip", "", gzipBytes(body), true},
+	}
+	for _, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Accept-Encoding") != "gzip" {
+				t.Error("Expected gzip to be accepted")
+			}
+			if c.encoding != "" {
+				w.Header().Set("Content-Encoding", c.encoding)
+			}
+			w.Write(c.body)
+		}))
+		client := NewPushClient(&ClientConfig{Host: server.URL})
+		responses, err := client.PublishMultiple(context.Background(), testMessages(1))
+		server.Close()
+
+		var malformed *MalformedResponseError
+		if c.malformed {
+			if !errors.As(err, &malformed) {
+				t.Errorf("%s: expected a malformed response error, got %v", c.name, err)
+			} else if malformed.Encoding != c.encoding || malformed.Snippet == "" {
+				t.Errorf("%s: expected the encoding and a body snippet, got %+v", c.name, malformed)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if responses[0].ID != "receipt" {
+			t.Errorf("%s: response wasn't decoded", c.name)
+		}
+	}
+}
+
+func TestSentAtStamped(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{Host: server.URL, Clock: clock})
+
+	responses, err := client.PublishMultiple(context.Background(), testMessages(MaxNotificationsPerRequest+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range responses {
+		if !r.SentAt.Equal(clock.Now()) {
+			t.Fatalf("Response %d SentAt %s, expected %s", i, r.SentAt, clock.Now())
+		}
+	}
+}
+
+// newFailingChunkServer returns a server which fails any request containing
+// the notification for token with a 400, accepting every other request
+func newFailingChunkServer(t *testing.T, token string, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(requests, 1)
+		body, _ := io.ReadAll(req.Body)
+		if bytes.Contains(body, []byte(token)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		acceptAll(t, w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	}))
+}
+
+func TestLogFailedBodies(t *testing.T) {
+	var requests int32
+	server := newFailingChunkServer(t, "ExponentPushToken[0]", &requests)
+	defer server.Close()
+	var logs bytes.Buffer
+	client := NewPushClient(&ClientConfig{
+		Host:            server.URL,
+		Logger:          log.New(&logs, "", 0),
+		LogFailedBodies: true,
+	})
+
+	messages := testMessages(2 * MaxNotificationsPerRequest)
+	if _, err := client.PublishMultiple(context.Background(), messages, WithBatchID("post-mortem")); err == nil {
+		t.Fatal("Expected the first chunk to fail")
+	}
+	out := logs.String()
+	if !strings.Contains(out, "batch post-mortem: chunk 0 body: ") || !strings.Contains(out, `"body":"message 5"`) {
+		t.Errorf("Expected the failed chunk's body to be logged, got %s", out)
+	}
+	if strings.Contains(out, "ExponentPushToken[5]") || !strings.Contains(out, "ExponentPushToken[REDACTED]") {
+		t.Error("Expected the logged body to be redacted")
+	}
+	if strings.Contains(out, "chunk 1 body") || strings.Contains(out, "message 150") {
+		t.Errorf("Expected no body to be logged for the successful chunk, got %s", out)
+	}
+}
+
+func TestPublishMultipleBestEffort(t *testing.T) {
+	var requests int32
+	server := newFailingChunkServer(t, "ExponentPushToken[0]", &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	messages := testMessages(3 * MaxNotificationsPerRequest)
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) || chunkErr.Index != 0 {
+		t.Fatalf("Expected the first chunk's error, got %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected every chunk to be sent, got %d requests", requests)
+	}
+	if len(responses) != 2*MaxNotificationsPerRequest {
+		t.Fatalf("Expected the successful chunks' responses, got %d", len(responses))
+	}
+	if responses[0].PushMessage.To[0] != messages[MaxNotificationsPerRequest].To[0] {
+		t.Error("Responses aren't in chunk order")
+	}
+}
+
+func TestPublishMultipleFailFast(t *testing.T) {
+	var requests int32
+	server := newFailingChunkServer(t, "ExponentPushToken[0]", &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, FailFast: true})
+
+	_, err := client.PublishMultiple(context.Background(), testMessages(3*MaxNotificationsPerRequest))
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) || chunkErr.Index != 0 {
+		t.Fatalf("Expected the first chunk's error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected the remaining chunks to be skipped, got %d requests", requests)
+	}
+}
+
+func TestPublishMultipleConcurrent(t *testing.T) {
+	var inFlight, maxInFlight, requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		acceptAll(t, w, req)
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, Concurrency: 3})
+
+	messages := testMessages(6 * MaxNotificationsPerRequest)
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxInFlight < 2 || maxInFlight > 3 {
+		t.Errorf("Expected up to 3 concurrent requests, got %d", maxInFlight)
+	}
+	for i, r := range responses {
+		if r.PushMessage.To[0] != messages[i].To[0] {
+			t.Fatalf("Response %d isn't mapped to its message", i)
+		}
+	}
+}
+
+func TestUnauthorizedShapes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		if req.Header.Get("Authorization") == "" {
+			w.Write([]byte(`{"errors": [{"code": "UNAUTHORIZED", "message": "This project requires an access token"}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors": [{"code": "UNAUTHORIZED", "message": "The access token is invalid"}]}`))
+	}))
+	defer server.Close()
+
+	_, err := NewPushClient(&ClientConfig{Host: server.URL}).PublishMultiple(context.Background(), testMessages(1))
+	var missing *InvalidCredentialsError
+	if !errors.As(err, &missing) || missing.TokenProvided {
+		t.Fatalf("Expected a missing token error, got %v", err)
+	}
+	if !strings.Contains(missing.Error(), "No access token provided") || !strings.Contains(missing.Error(), "requires an access token") {
+		t.Errorf("Unexpected message %q", missing.Error())
+	}
+
+	_, err = NewPushClient(&ClientConfig{Host: server.URL, AccessToken: "revoked"}).PublishMultiple(context.Background(), testMessages(1))
+	var rejected *InvalidCredentialsError
+	if !errors.As(err, &rejected) || !rejected.TokenProvided {
+		t.Fatalf("Expected a rejected token error, got %v", err)
+	}
+	if !strings.Contains(rejected.Error(), "Access token rejected") || !strings.Contains(rejected.Error(), "is invalid") {
+		t.Errorf("Unexpected message %q", rejected.Error())
+	}
+}
+
+func TestRequireAccessToken(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, RequireAccessToken: true})
+
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); !errors.Is(err, ErrMissingAccessToken) {
+		t.Errorf("Expected ErrMissingAccessToken, got %v", err)
+	}
+	if _, err := client.GetPushNotificationReceipts(context.Background(), []string{"receipt"}); !errors.Is(err, ErrMissingAccessToken) {
+		t.Errorf("Expected ErrMissingAccessToken for receipts, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no requests without a token, got %d", requests)
+	}
+
+	client = NewPushClient(&ClientConfig{Host: server.URL, RequireAccessToken: true, AccessToken: "token"})
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Errorf("Expected a send with a token to succeed, got %v", err)
+	}
+}
+
+func TestOnSuccess(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	var mu sync.Mutex
+	succeeded := map[string]int{}
+	client := NewPushClient(&ClientConfig{
+		Host:        server.URL,
+		Concurrency: 3,
+		OnSuccess: func(r PushResponse) {
+			mu.Lock()
+			defer mu.Unlock()
+			succeeded[r.PushMessage.To[0]]++
+		},
+		OnError: func(r PushResponse, err error) {
+			t.Errorf("Unexpected failure %v", err)
+		},
+	})
+
+	messages := testMessages(2*MaxNotificationsPerRequest + 1)
+	if _, err := client.PublishMultiple(context.Background(), messages); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 || len(succeeded) != len(messages) {
+		t.Fatalf("Expected %d tokens reported across 3 chunks, got %d in %d", len(messages), len(succeeded), requests)
+	}
+	for token, n := range succeeded {
+		if n != 1 {
+			t.Errorf("Expected %s to be reported once, got %d", token, n)
+		}
+	}
+}
+
+func TestTokenRouter(t *testing.T) {
+	var mu sync.Mutex
+	authByToken := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		var messages []PushMessage
+		json.Unmarshal(body, &messages)
+		mu.Lock()
+		for _, m := range messages {
+			authByToken[m.To[0]] = req.Header.Get("Authorization")
+		}
+		mu.Unlock()
+		acceptAll(t, w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{
+		Host:        server.URL,
+		AccessToken: "default-token",
+		TokenRouter: func(m PushMessage) string {
+			return map[string]string{"a": "token-a", "b": "token-b"}[m.Metadata["tenant"]]
+		},
+	})
+
+	messages := testMessages(4)
+	for i, tenant := range []string{"a", "b", "a", "other"} {
+		messages[i].Metadata = map[string]string{"tenant": tenant}
+	}
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tenant := range []string{"a", "a", "b", "other"} {
+		if responses[i].Metadata["tenant"] != tenant {
+			t.Errorf("Expected response %d to be grouped by token, for tenant %q, got %q", i, tenant, responses[i].Metadata["tenant"])
+		}
+	}
+	expected := []string{"Bearer token-a", "Bearer token-b", "Bearer token-a", "Bearer default-token"}
+	for i, m := range messages {
+		if auth := authByToken[m.To[0]]; auth != expected[i] {
+			t.Errorf("Message %d: expected %q, got %q", i, expected[i], auth)
+		}
+	}
+	if chunks, _ := client.PlanRequests(messages); chunks != 3 {
+		t.Errorf("Expected a request per access token, got %d", chunks)
+	}
+}
+
+func TestBatchIDInCallbacks(t *testing.T) {
+	var requests int32
+	server := newFailingChunkServer(t, "ExponentPushToken[0]", &requests)
+	defer server.Close()
+	var mu sync.Mutex
+	var failed []PushResponse
+	var logs bytes.Buffer
+	client := NewPushClient(&ClientConfig{
+		Host:   server.URL,
+		Logger: log.New(&logs, "", 0),
+		OnError: func(r PushResponse, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, r)
+		},
+	})
+
+	messages := testMessages(2 * MaxNotificationsPerRequest)
+	responses, err := client.PublishMultiple(context.Background(), messages, WithBatchID("batch-1"))
+	if err == nil {
+		t.Fatal("Expected the first chunk to fail")
+	}
+	if len(failed) != MaxNotificationsPerRequest {
+		t.Fatalf("Expected a callback per failed notification, got %d", len(failed))
+	}
+	for _, r := range failed {
+		if r.BatchID != "batch-1" {
+			t.Fatalf("Expected the batch ID in callback responses, got %q", r.BatchID)
+		}
+	}
+	for _, r := range responses {
+		if r.BatchID != "batch-1" {
+			t.Fatalf("Expected the batch ID in responses, got %q", r.BatchID)
+		}
+	}
+	if !strings.Contains(logs.String(), "batch batch-1: chunk 0 failed") {
+		t.Errorf("Expected the batch ID in logs, got %q", logs.String())
+	}
+
+	first, _ := client.PublishMultiple(context.Background(), messages[MaxNotificationsPerRequest:])
+	second, _ := client.PublishMultiple(context.Background(), messages[MaxNotificationsPerRequest:])
+	if first[0].BatchID == "" || first[0].BatchID == second[0].BatchID {
+		t.Error("Expected a distinct batch ID to be generated for each call")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(time.Second):
+			acceptAll(t, w, req)
+		}
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, RequestTimeout: time.Minute})
+
+	start := time.Now()
+	_, err := client.WithTimeout(20*time.Millisecond).PublishMultiple(context.Background(), testMessages(1))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the request to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the shorter client timeout to win, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = client.WithTimeout(time.Minute).PublishMultiple(ctx, testMessages(1))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the request to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the shorter caller deadline to win, took %s", elapsed)
+	}
+	if client.timeout != time.Minute {
+		t.Error("WithTimeout modified the original client")
+	}
+}
+
+func TestSkipEmptyMessages(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	messages := testMessages(3)
+	messages[1].To = nil
+
+	if _, err := NewPushClient(&ClientConfig{Host: server.URL}).PublishMultiple(context.Background(), messages); err == nil {
+		t.Error("Expected an error for an empty message by default")
+	}
+
+	client := NewPushClient(&ClientConfig{Host: server.URL, SkipEmptyMessages: true})
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 || responses[1].PushMessage.To[0] != messages[2].To[0] {
+		t.Errorf("Expected responses for just the non-empty messages, got %d", len(responses))
+	}
+
+	responses, err = client.PublishMultiple(context.Background(), []PushMessage{{Body: "nobody"}})
+	if err != nil || len(responses) != 0 {
+		t.Errorf("Expected no responses and no error, got %d and %v", len(responses), err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected a single request, got %d", requests)
+	}
+}
+
+// deadlineTransport records the time remaining before each request's deadline
+type deadlineTransport struct {
+	mu        sync.Mutex
+	remaining []time.Duration
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if deadline, ok := req.Context().Deadline(); ok {
+		t.mu.Lock()
+		t.remaining = append(t.remaining, time.Until(deadline))
+		t.mu.Unlock()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTimeoutScale(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	transport := &deadlineTransport{}
+	client := NewPushClient(&ClientConfig{
+		Host:           server.URL,
+		Transport:      transport,
+		RequestTimeout: time.Second,
+		TimeoutScale:   &TimeoutScale{PerNotification: 100 * time.Millisecond, Max: 5 * time.Second},
+	})
+
+	for _, n := range []int{1, 20, MaxNotificationsPerRequest} {
+		if _, err := client.PublishMultiple(context.Background(), testMessages(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []time.Duration{1100 * time.Millisecond, 3 * time.Second, 5 * time.Second}
+	if len(transport.remaining) != len(expected) {
+		t.Fatalf("Expected %d requests with deadlines, got %d", len(expected), len(transport.remaining))
+	}
+	// The deadline is in wall time, so allow for the time taken to reach the transport
+	const tolerance = 100 * time.Millisecond
+	for i, d := range expected {
+		if remaining := transport.remaining[i]; remaining > d || remaining < d-tolerance {
+			t.Errorf("Expected request %d to have about %s, got %s", i, d, remaining)
+		}
+	}
+
+	client.timeoutScale = nil
+	if timeout := client.requestTimeout(MaxNotificationsPerRequest); timeout != time.Second {
+		t.Errorf("Expected an unscaled timeout without TimeoutScale, got %s", timeout)
+	}
+}
+
+func TestRequestTimeoutIgnoresClock(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	client := NewPushClient(&ClientConfig{Host: server.URL, Clock: clock, RequestTimeout: 10 * time.Second})
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Errorf("Expected the timeout to be measured in wall time, not by the clock, got %v", err)
+	}
+}
+
+func TestPublishMultipleResultStats(t *testing.T) {
+	var requests int32
+	server := newFlakyServer(t, 1, &requests)
+	defer server.Close()
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{
+		Host:  server.URL,
+		Clock: clock,
+		Retry: &RetryConfig{MaxRetries: 1, InitialBackoff: time.Second, Jitter: NoJitter},
+	})
+
+	result := client.PublishMultipleResult(context.Background(), testMessages(2*MaxNotificationsPerRequest), WithBatchID("stats"))
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if len(result.Responses) != 2*MaxNotificationsPerRequest {
+		t.Errorf("Expected every response, got %d", len(result.Responses))
+	}
+	if result.Chunks != 2 || result.FailedChunks != 0 || result.Retries != 1 {
+		t.Errorf("Expected 2 chunks, 0 failed and 1 retry, got %d, %d and %d", result.Chunks, result.FailedChunks, result.Retries)
+	}
+	if result.BatchID != "stats" {
+		t.Errorf("Expected the batch ID, got %q", result.BatchID)
+	}
+	if result.Duration != time.Second {
+		t.Errorf("Expected the backoff to be included in the duration, got %s", result.Duration)
+	}
+	if stats := client.Stats(); stats.Retried != 1 || stats.Sent != 2*MaxNotificationsPerRequest {
+		t.Errorf("Expected the retry and sends in the client stats, got %+v", stats)
+	}
+}
+
+// errDeviceSuspended is a caller defined error for a code unknown to the package
+var errDeviceSuspended = errors.New("device suspended")
+
+func TestClassifyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"data": [
+			{"status": "error", "message": "suspended", "details": {"error": "DeviceSuspended"}},
+			{"status": "error", "message": "gone", "details": {"error": "DeviceNotRegistered"}}
+		]}`))
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{
+		Host: server.URL,
+		ClassifyError: func(details map[string]json.RawMessage) error {
+			if detailsString(details["error"]) == "DeviceSuspended" {
+				return errDeviceSuspended
+			}
+			return nil
+		},
+	})
+
+	responses, err := client.PublishMultiple(context.Background(), testMessages(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := responses[0].ValidateResponse(); err != errDeviceSuspended {
+		t.Errorf("Expected the custom classification, got %v", err)
+	}
+	if _, ok := responses[1].ValidateResponse().(*DeviceNotRegisteredError); !ok {
+		t.Error("Expected the built-in classification when the classifier returns nil")
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		acceptAll(t, w, req)
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, Concurrency: 4, MaxInFlight: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.PublishMultiple(context.Background(), testMessages(4*MaxNotificationsPerRequest)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInFlight != 2 {
+		t.Errorf("Expected at most 2 requests in flight, got %d", maxInFlight)
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	var requests int32
+	server := newFailingChunkServer(t, "ExponentPushToken[0]", &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	messages := testMessages(3 * MaxNotificationsPerRequest)
+	client.PublishMultiple(context.Background(), messages)
+	stats := client.Stats()
+	if stats.Sent != 2*MaxNotificationsPerRequest || stats.Failed != MaxNotificationsPerRequest {
+		t.Errorf("Expected %d sent and %d failed, got %+v", 2*MaxNotificationsPerRequest, MaxNotificationsPerRequest, stats)
+	}
+	bodies, _ := client.MarshalBatch(messages)
+	var requestBytes int64
+	for _, b := range bodies {
+		requestBytes += int64(len(b))
+	}
+	if stats.Bytes <= requestBytes {
+		t.Errorf("Expected request and response bytes to be counted, got %d", stats.Bytes)
+	}
+
+	client.WithTimeout(time.Minute).PublishMultiple(context.Background(), messages[MaxNotificationsPerRequest:])
+	if got := client.Stats().Sent; got != 4*MaxNotificationsPerRequest {
+		t.Errorf("Expected counters to accumulate across calls and clones, got %d", got)
+	}
+}
+
+func TestPublishMultipleMap(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	messages := []PushMessage{
+		{To: []string{"ExponentPushToken[a]", "ExponentPushToken[b]"}, Body: "first"},
+		{To: []string{"ExponentPushToken[a]"}, Body: "second"},
+	}
+	byToken, err := client.PublishMultipleMap(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byToken) != 2 {
+		t.Fatalf("Expected a response per distinct token, got %d", len(byToken))
+	}
+	if byToken["ExponentPushToken[a]"].PushMessage.Body != "second" {
+		t.Error("Expected the last response to win for a duplicate token")
+	}
+	if byToken["ExponentPushToken[b]"].PushMessage.Body != "first" {
+		t.Error("Expected the response of a unique token")
+	}
+}
+
+// newSizeLimitServer returns a server which rejects request bodies larger
+// than limit bytes with a 413, accepting every other request
+func newSizeLimitServer(t *testing.T, limit int, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(requests, 1)
+		body, _ := io.ReadAll(req.Body)
+		if len(body) > limit {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		acceptAll(t, w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	}))
+}
+
+func TestPayloadTooLarge(t *testing.T) {
+	var requests int32
+	messages := testMessages(8)
+	bodies, _ := NewPushClient(nil).MarshalBatch(messages)
+	server := newSizeLimitServer(t, len(bodies[0])/3, &requests)
+	defer server.Close()
+
+	_, err := NewPushClient(&ClientConfig{Host: server.URL}).PublishMultiple(context.Background(), messages)
+	var tooLarge *PayloadTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Size != int64(len(bodies[0])) {
+		t.Fatalf("Expected a payload too large error with the body size, got %v", err)
+	}
+
+	requests = 0
+	client := NewPushClient(&ClientConfig{Host: server.URL, MaxChunkBytes: 1 << 20})
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != len(messages) || requests <= 1 {
+		t.Fatalf("Expected the chunk to be split and resent, got %d responses in %d requests", len(responses), requests)
+	}
+	for i, r := range responses {
+		if r.PushMessage.To[0] != messages[i].To[0] {
+			t.Fatalf("Response %d isn't mapped to its message", i)
+		}
+	}
+}
+
+func TestPayloadTooLargeSplitRemainder(t *testing.T) {
+	messages := testMessages(2)
+	bodies, _ := NewPushClient(nil).MarshalBatch(messages)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		switch {
+		case len(body) >= len(bodies[0]):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		case bytes.Contains(body, []byte(messages[1].To[0])):
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			acceptAll(t, w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+		}
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, MaxChunkBytes: 1 << 20})
+
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("Expected a chunk error, got %v", err)
+	}
+	if len(responses) != 1 || responses[0].PushMessage.To[0] != messages[0].To[0] {
+		t.Errorf("Expected the first half's response, got %+v", responses)
+	}
+	if len(chunkErr.Messages) != 1 || chunkErr.Messages[0].To[0] != messages[1].To[0] {
+		t.Errorf("Expected only the unsent second half in the chunk error, got %+v", chunkErr.Messages)
+	}
+}
+
+func TestMaxChunkBytes(t *testing.T) {
+	messages := testMessages(20)
+	single, _ := NewPushClient(nil).MarshalBatch(messages[:1])
+	limit := 5 * len(single[0])
+	bodies, err := NewPushClient(&ClientConfig{MaxChunkBytes: limit}).MarshalBatch(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) < 4 {
+		t.Errorf("Expected the batch to be split by size, got %d chunks", len(bodies))
+	}
+	var decoded int
+	for _, b := range bodies {
+		if len(b) > limit {
+			t.Errorf("Chunk of %d bytes exceeds the %d byte limit", len(b), limit)
+		}
+		var chunk []PushMessage
+		json.Unmarshal(b, &chunk)
+		decoded += len(chunk)
+	}
+	if decoded != len(messages) {
+		t.Errorf("Expected every message across the chunks, got %d", decoded)
+	}
+}
+
+func TestPlanRequests(t *testing.T) {
+	single, _ := NewPushClient(nil).MarshalBatch(testMessages(1))
+	cases := []struct {
+		messages int
+		config   ClientConfig
+		want     int
+	}{
+		{0, ClientConfig{}, 0},
+		{1, ClientConfig{}, 1},
+		{MaxNotificationsPerRequest, ClientConfig{}, 1},
+		{MaxNotificationsPerRequest + 1, ClientConfig{}, 2},
+		{25, ClientConfig{ChunkSize: 10}, 3},
+		{20, ClientConfig{MaxChunkBytes: 5 * len(single[0])}, -1},
+		{20, ClientConfig{ChunkSize: 2, MaxChunkBytes: 5 * len(single[0])}, 10},
+	}
+	for _, tc := range cases {
+		config := tc.config
+		client := NewPushClient(&config)
+		messages := testMessages(tc.messages)
+		got, err := client.PlanRequests(messages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := tc.want
+		if want < 0 {
+			bodies, _ := client.MarshalBatch(messages)
+			want = len(bodies)
+			if want < 4 {
+				t.Errorf("Expected the byte budget to split the batch, got %d chunks", want)
+			}
+		}
+		if got != want {
+			t.Errorf("%d messages with %+v: expected %d requests, got %d", tc.messages, tc.config, want, got)
+		}
+	}
+
+	if _, err := NewPushClient(nil).PlanRequests([]PushMessage{{Body: "no recipients"}}); err == nil {
+		t.Error("Expected an invalid batch to fail planning")
+	}
+}
+
+func TestOnProgress(t *testing.T) {
+	var requests int32
+	server := newFlakyServer(t, 2, &requests)
+	defer server.Close()
+	var mu sync.Mutex
+	var calls [][2]int
+	client := NewPushClient(&ClientConfig{
+		Host:        server.URL,
+		Clock:       newFakeClock(),
+		ChunkSize:   10,
+		Concurrency: 3,
+		Retry:       &RetryConfig{MaxRetries: 3, InitialBackoff: time.Second},
+		OnProgress: func(sent, total int) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, [2]int{sent, total})
+		},
+	})
+	if _, err := client.PublishMultiple(context.Background(), testMessages(45)); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 5 {
+		t.Fatalf("Expected one call per chunk, got %v", calls)
+	}
+	for i, call := range calls {
+		if call[1] != 45 || (i > 0 && call[0] <= calls[i-1][0]) {
+			t.Errorf("Expected monotonic progress out of 45, got %v", calls)
+			break
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != 45 {
+		t.Errorf("Expected progress to reach the total despite retries, got %v", last)
+	}
+}
+
+func TestRequestContentLength(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		var contentLength int64
+		var transferEncoding []string
+		var encoding string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			contentLength = req.ContentLength
+			transferEncoding = req.TransferEncoding
+			encoding = req.Header.Get("Content-Encoding")
+			body := req.Body
+			if encoding == "gzip" {
+				zr, err := gzip.NewReader(req.Body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				body = zr
+			}
+			acceptAll(t, w, httptest.NewRequest("POST", "/", body))
+		}))
+		client := NewPushClient(&ClientConfig{Host: server.URL, CompressRequests: compress})
+		req, err := client.buildRequest(context.Background(), client.SendURL(), []byte(`[]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.ContentLength <= 0 || req.GetBody == nil {
+			t.Errorf("compress=%v: expected a fixed length, replayable body", compress)
+		}
+
+		if _, err := client.PublishMultiple(context.Background(), testMessages(3)); err != nil {
+			t.Fatalf("compress=%v: %v", compress, err)
+		}
+		server.Close()
+		if contentLength <= 0 || len(transferEncoding) != 0 {
+			t.Errorf("compress=%v: expected a Content-Length and no chunked encoding, got %d and %v", compress, contentLength, transferEncoding)
+		}
+		if compress != (encoding == "gzip") {
+			t.Errorf("compress=%v: unexpected Content-Encoding %q", compress, encoding)
+		}
+	}
+}
+
+func TestBodyTransformer(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received, _ = io.ReadAll(req.Body)
+		unwrapped := bytes.TrimSuffix(bytes.TrimPrefix(received, []byte(`{"payload":`)), []byte(`}`))
+		acceptAll(t, w, httptest.NewRequest("POST", "/", bytes.NewReader(unwrapped)))
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{
+		Host: server.URL,
+		BodyTransformer: func(body []byte) []byte {
+			return append(append([]byte(`{"payload":`), body...), '}')
+		},
+	})
+	if _, err := client.PublishMultiple(context.Background(), testMessages(2)); err != nil {
+		t.Fatal(err)
+	}
+	var wrapped struct {
+		Payload []PushMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(received, &wrapped); err != nil || len(wrapped.Payload) != 2 {
+		t.Errorf("Expected the wrapped body to reach the server, got %s", received)
+	}
+}
+
+func TestSendSimple(t *testing.T) {
+	server := newMixedServer(t)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	response, err := SendSimple(context.Background(), client, "ExponentPushToken[a]", "Hello", "World")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != SuccessStatus || response.PushMessage.Title != "Hello" || response.PushMessage.Body != "World" {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	if _, err := SendSimple(context.Background(), client, "not-a-token", "Hello", "World"); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("Expected a malformed token error, got %v", err)
+	}
+	if _, err := SendSimple(context.Background(), client, "ExponentPushToken[gone]", "Hello", "World"); !errors.As(err, new(*DeviceNotRegisteredError)) {
+		t.Errorf("Expected the rejection as an error, got %v", err)
+	}
+}
+
+func TestShortResponseSalvaged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"data": [{"status": "ok", "id": "first"}, {"status": "ok", "id": "second"}]}`))
+	}))
+	defer server.Close()
+	var reported []string
+	client := NewPushClient(&ClientConfig{
+		Host:    server.URL,
+		OnError: func(r PushResponse, err error) { reported = append(reported, r.PushMessage.To[0]) },
+	})
+
+	messages := testMessages(3)
+	responses, err := client.PublishMultiple(context.Background(), messages)
+	var mismatch *ResponseLengthMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Expected != 3 || mismatch.Received != 2 || len(mismatch.Data) != 2 {
+		t.Fatalf("Expected a length mismatch error with the raw data, got %v", err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected the 2 received responses, got %d", len(responses))
+	}
+	for i, r := range responses {
+		if r.PushMessage.To[0] != messages[i].To[0] {
+			t.Errorf("Response %d isn't mapped to its message", i)
+		}
+	}
+	if responses[1].ID != "second" {
+		t.Errorf("Unexpected response %+v", responses[1])
+	}
+	if len(reported) != 1 || reported[0] != messages[2].To[0] {
+		t.Errorf("Expected only the unanswered notification to be reported, got %v", reported)
+	}
+}
+
+func TestReplayShortResponse(t *testing.T) {
+	var replayed []string
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Write([]byte(`{"data": [{"status": "ok"}, {"status": "ok"}]}`))
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		var messages []PushMessage
+		json.Unmarshal(body, &messages)
+		for _, m := range messages {
+			replayed = append(replayed, m.To...)
+		}
+		acceptAll(t, w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	// The short response answers the first message and part of the second
+	messages := testMessages(4)
+	messages[1].To = append(messages[1].To, messages[2].To...)
+	messages = append(messages[:2], messages[3])
+	_, err := client.PublishMultiple(context.Background(), messages)
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("Expected a chunk error, got %v", err)
+	}
+	blob, err := client.SerializeBatch(chunkErr.Messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReplayBatch(context.Background(), blob); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ExponentPushToken[2]", "ExponentPushToken[3]"}
+	if fmt.Sprint(replayed) != fmt.Sprint(expected) {
+		t.Errorf("Expected only the unanswered notifications to be replayed, got %v", replayed)
+	}
+}
+
+func TestHTTPClientsPool(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	transports := []*countingTransport{{}, {}, {}}
+	var clients []*http.Client
+	for _, transport := range transports {
+		clients = append(clients, &http.Client{Transport: transport})
+	}
+	client := NewPushClient(&ClientConfig{Host: server.URL, HTTPClients: clients})
+
+	if _, err := client.PublishMultiple(context.Background(), testMessages(6*MaxNotificationsPerRequest)); err != nil {
+		t.Fatal(err)
+	}
+	for i, transport := range transports {
+		if transport.requests != 2 {
+			t.Errorf("Expected client %d to send 2 requests, got %d", i, transport.requests)
+		}
+	}
+}
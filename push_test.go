@@ -2,6 +2,7 @@ package expo
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -78,3 +79,257 @@ func TestValidateResponseErrorMessageRateExceeded(t *testing.T) {
 		t.Error("Didn't return called response")
 	}
 }
+
+func TestMarshalDisplayInForeground(t *testing.T) {
+	cases := []struct {
+		value    *bool
+		expected string
+	}{
+		{Bool(true), `"_displayInForeground":true`},
+		{Bool(false), `"_displayInForeground":false`},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		b, err := json.Marshal(&PushMessage{DisplayInForeground: c.value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.expected == "" {
+			if strings.Contains(string(b), "_displayInForeground") {
+				t.Errorf("Expected unset field to be omitted, got %s", b)
+			}
+		} else if !strings.Contains(string(b), c.expected) {
+			t.Errorf("Expected %s in %s", c.expected, b)
+		}
+	}
+}
+
+func TestShouldRemoveToken(t *testing.T) {
+	registered := PushResponse{Status: "ok"}
+	if registered.ShouldRemoveToken() {
+		t.Error("Flagged a successful response for removal")
+	}
+	failed := PushResponse{
+		Status:  "error",
+		Details: map[string]json.RawMessage{"error": []byte("MessageTooBig")},
+	}
+	if failed.ShouldRemoveToken() {
+		t.Error("Flagged an unrelated error for removal")
+	}
+	unregistered := PushResponse{
+		Status:  "error",
+		Details: map[string]json.RawMessage{"error": []byte("DeviceNotRegistered")},
+	}
+	if !unregistered.ShouldRemoveToken() {
+		t.Error("Didn't flag an unregistered device for removal")
+	}
+}
+
+func TestNormalizePriority(t *testing.T) {
+	cases := map[string]string{
+		"High":     HighPriority,
+		" HIGH ":   HighPriority,
+		"normal":   NormalPriority,
+		"Normal":   NormalPriority,
+		"DEFAULT":  DefaultPriority,
+		"10":       HighPriority,
+		"5":        NormalPriority,
+		"":         "",
+		"Whenever": "whenever",
+	}
+	for input, expected := range cases {
+		if got := NormalizePriority(input); got != expected {
+			t.Errorf("NormalizePriority(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestMarshalOptionalNumbers(t *testing.T) {
+	cases := []struct {
+		message  PushMessage
+		present  []string
+		excluded []string
+	}{
+		{PushMessage{}, nil, []string{`"ttl"`, `"badge"`, `"expiration"`}},
+		{PushMessage{TTLSeconds: Int(0), Badge: Int(0)}, []string{`"ttl":0`, `"badge":0`}, []string{`"expiration"`}},
+		{PushMessage{TTLSeconds: Int(60), Badge: Int(2), Expiration: 1700000000}, []string{`"ttl":60`, `"badge":2`, `"expiration":1700000000`}, nil},
+	}
+	for _, c := range cases {
+		b, err := json.Marshal(&c.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range c.present {
+			if !strings.Contains(string(b), field) {
+				t.Errorf("Expected %s in %s", field, b)
+			}
+		}
+		for _, field := range c.excluded {
+			if strings.Contains(string(b), field) {
+				t.Errorf("Expected %s to be omitted from %s", field, b)
+			}
+		}
+	}
+}
+
+func TestMarshalTargetContentID(t *testing.T) {
+	b, _ := json.Marshal(&PushMessage{TargetContentID: "chat-42"})
+	if !strings.Contains(string(b), `"targetContentId":"chat-42"`) {
+		t.Errorf("Expected targetContentId in %s", b)
+	}
+	b, _ = json.Marshal(&PushMessage{})
+	if strings.Contains(string(b), "targetContentId") {
+		t.Errorf("Expected unset field to be omitted, got %s", b)
+	}
+}
+
+func TestMarshalCollapseIDs(t *testing.T) {
+	b, _ := json.Marshal(&PushMessage{APNSCollapseID: "score", AndroidCollapseKey: "score-update"})
+	if !strings.Contains(string(b), `"collapseId":"score"`) || !strings.Contains(string(b), `"collapseKey":"score-update"`) {
+		t.Errorf("Expected collapseId and collapseKey in %s", b)
+	}
+	b, _ = json.Marshal(&PushMessage{})
+	if strings.Contains(string(b), "collapse") {
+		t.Errorf("Expected unset fields to be omitted, got %s", b)
+	}
+}
+
+func TestErrorCodeAndMessage(t *testing.T) {
+	response := &PushResponse{
+		Status:  "error",
+		Message: "The recipient device is not registered",
+		Details: map[string]json.RawMessage{
+			"error": []byte(`"DeviceNotRegistered"`),
+			"code":  []byte(`"PUSH_TOO_MANY_EXPERIENCE_IDS"`),
+		},
+	}
+	typed, ok := response.ValidateResponse().(*DeviceNotRegisteredError)
+	if !ok {
+		t.Fatal("Incorrect error type")
+	}
+	if typed.Code() != "PUSH_TOO_MANY_EXPERIENCE_IDS" {
+		t.Errorf("Unexpected code %q", typed.Code())
+	}
+	if typed.Message() != "The recipient device is not registered" {
+		t.Errorf("Unexpected message %q", typed.Message())
+	}
+
+	response.Details = map[string]json.RawMessage{
+		"code":    []byte(`"MessageTooBig"`),
+		"message": []byte(`"Payload exceeds 4096 bytes"`),
+	}
+	tooBig, ok := response.ValidateResponse().(*MessageTooBigError)
+	if !ok {
+		t.Fatal("Expected classification from the code detail")
+	}
+	if tooBig.Code() != "MessageTooBig" || tooBig.Message() != "Payload exceeds 4096 bytes" {
+		t.Errorf("Unexpected code %q and message %q", tooBig.Code(), tooBig.Message())
+	}
+}
+
+func TestMarshalContentAvailable(t *testing.T) {
+	b, _ := json.Marshal(PushMessage{ContentAvailable: true})
+	if !strings.Contains(string(b), `"_contentAvailable":true`) {
+		t.Errorf("Expected _contentAvailable in %s", b)
+	}
+	b, _ = json.Marshal(PushMessage{})
+	if strings.Contains(string(b), "_contentAvailable") {
+		t.Errorf("Expected _contentAvailable to be omitted in %s", b)
+	}
+}
+
+func TestValidateResponseErrorKeys(t *testing.T) {
+	for _, key := range []string{"error", "fault", "code"} {
+		response := &PushResponse{
+			Status:  "error",
+			Details: map[string]json.RawMessage{key: []byte(`"DeviceNotRegistered"`)},
+		}
+		if _, ok := response.ValidateResponse().(*DeviceNotRegisteredError); !ok {
+			t.Errorf("Expected the code under %q to be classified", key)
+		}
+	}
+
+	response := &PushResponse{
+		Status: "error",
+		Details: map[string]json.RawMessage{
+			"code":  []byte(`"MessageRateExceeded"`),
+			"fault": []byte(`"MessageTooBig"`),
+			"error": []byte(`"DeviceNotRegistered"`),
+		},
+	}
+	if _, ok := response.ValidateResponse().(*DeviceNotRegisteredError); !ok {
+		t.Error("Expected error to take precedence")
+	}
+	delete(response.Details, "error")
+	if _, ok := response.ValidateResponse().(*MessageTooBigError); !ok {
+		t.Error("Expected fault to take precedence over code")
+	}
+}
+
+func TestAPNSPushType(t *testing.T) {
+	cases := []struct {
+		message  PushMessage
+		expected string
+	}{
+		{PushMessage{ContentAvailable: true}, APNSPushTypeBackground},
+		{PushMessage{ContentAvailable: true, Data: map[string]interface{}{"sync": 1}}, APNSPushTypeBackground},
+		{PushMessage{ContentAvailable: true, Sound: "default"}, APNSPushTypeAlert},
+		{PushMessage{ContentAvailable: true, Badge: Int(0)}, APNSPushTypeAlert},
+		{PushMessage{ContentAvailable: true, Body: "hi"}, APNSPushTypeAlert},
+		{PushMessage{}, APNSPushTypeAlert},
+	}
+	for i, c := range cases {
+		if got := c.message.APNSPushType(); got != c.expected {
+			t.Errorf("Case %d: expected %s, got %s", i, c.expected, got)
+		}
+	}
+}
+
+func TestValidateResponseMissingStatus(t *testing.T) {
+	raw := `{"id":"receipt-1","message":"something changed"}`
+	var response PushResponse
+	if err := json.Unmarshal([]byte(raw), &response); err != nil {
+		t.Fatal(err)
+	}
+	typed, ok := response.ValidateResponse().(*MissingStatusError)
+	if !ok {
+		t.Fatalf("Expected a *MissingStatusError, got %v", response.ValidateResponse())
+	}
+	if string(typed.Raw) != raw || typed.Response.ID != "receipt-1" {
+		t.Errorf("Expected the raw item and response, got %s and %+v", typed.Raw, typed.Response)
+	}
+	if !strings.Contains(typed.Error(), raw) {
+		t.Errorf("Expected the raw item in the message, got %q", typed.Error())
+	}
+
+	if !strings.HasSuffix((&MissingStatusError{}).Error(), "may have changed") {
+		t.Errorf("Expected no raw suffix without a raw item, got %q", (&MissingStatusError{}).Error())
+	}
+
+	// Responses built for a failed chunk were never decoded
+	built := PushResponse{PushMessage: PushMessage{To: []string{"ExponentPushToken[a]"}}, BatchID: "batch"}
+	if _, ok := built.ValidateResponse().(*MissingStatusError); ok {
+		t.Error("Expected a response for a failed chunk not to be reported as a format change")
+	}
+
+	if err := json.Unmarshal([]byte(`{"status":"error","message":"failed"}`), &response); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := response.ValidateResponse().(*PushResponseError); !ok {
+		t.Errorf("Expected a generic error for an error status, got %v", response.ValidateResponse())
+	}
+}
+
+func TestNewExponentPushTokenWhitespace(t *testing.T) {
+	for _, padded := range []string{" ExponentPushToken[a]", "ExponentPushToken[a]\n", "\tExponentPushToken[a] \r\n"} {
+		token, err := NewExponentPushToken(padded)
+		if err != nil || token != "ExponentPushToken[a]" {
+			t.Errorf("%q: expected the trimmed token, got %q and %v", padded, token, err)
+		}
+	}
+	for _, interior := range []string{"ExponentPushToken[a b]", "ExponentPushToken[a\nb]", "ExponentPushToken[a b]"} {
+		if _, err := NewExponentPushToken(interior); err != ErrTokenWhitespace {
+			t.Errorf("%q: expected ErrTokenWhitespace, got %v", interior, err)
+		}
+	}
+}
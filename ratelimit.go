@@ -0,0 +1,86 @@
+package expo
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is the rate limit state reported by X-RateLimit-* response headers
+type RateLimit struct {
+	Remaining int       // Requests remaining in the current window, from X-RateLimit-Remaining
+	Reset     time.Time // When the window resets, from X-RateLimit-Reset
+}
+
+// parseRateLimit reads the X-RateLimit-* headers of a response, reporting
+// false if they are absent or malformed. X-RateLimit-Reset is accepted as
+// either a Unix timestamp or a number of seconds from now.
+func parseRateLimit(header http.Header, now time.Time) (RateLimit, bool) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	limit := RateLimit{Remaining: remaining, Reset: time.Unix(reset, 0)}
+	// Values too small to be a recent timestamp are relative
+	if reset < 1e9 {
+		limit.Reset = now.Add(time.Duration(reset) * time.Second)
+	}
+	return limit, true
+}
+
+// limiterPauses holds, for each limiter with a pause in effect, when sending
+// may resume, so that every client sharing the limiter pauses together.
+// Entries are removed once their pause has passed.
+var limiterPauses = struct {
+	mu    sync.Mutex
+	until map[*rate.Limiter]time.Time
+}{until: map[*rate.Limiter]time.Time{}}
+
+// observeRateLimit reports the rate limit headers of resp to the OnRateLimit
+// callback and, if the client has a limiter, pauses sending by every client
+// sharing it until the reset once at most RateLimitThreshold requests remain
+func (c *PushClient) observeRateLimit(resp *http.Response) {
+	limit, ok := parseRateLimit(resp.Header, c.clock.Now())
+	if !ok {
+		return
+	}
+	if c.onRateLimit != nil {
+		c.onRateLimit(limit)
+	}
+	if c.limiter == nil || limit.Remaining > c.rateLimitLow {
+		return
+	}
+	limiterPauses.mu.Lock()
+	defer limiterPauses.mu.Unlock()
+	if limit.Reset.After(limiterPauses.until[c.limiter]) {
+		limiterPauses.until[c.limiter] = limit.Reset
+	}
+}
+
+// waitPause blocks until any pause set by observeRateLimit on the client's limiter has passed
+func (c *PushClient) waitPause(ctx context.Context) error {
+	limiterPauses.mu.Lock()
+	until, ok := limiterPauses.until[c.limiter]
+	delay := until.Sub(c.clock.Now())
+	if ok && delay <= 0 {
+		delete(limiterPauses.until, c.limiter)
+	}
+	limiterPauses.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.clock.After(delay):
+		return nil
+	}
+}
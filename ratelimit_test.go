@@ -0,0 +1,127 @@
+package expo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitHeadersPause(t *testing.T) {
+	remaining := "0"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", remaining)
+		w.Header().Set("X-RateLimit-Reset", "3")
+		acceptAll(t, w, req)
+	}))
+	defer server.Close()
+	clock := newFakeClock()
+	var limits []RateLimit
+	client := NewPushClient(&ClientConfig{
+		Host:        server.URL,
+		Clock:       clock,
+		Limiter:     SharedLimiter(),
+		OnRateLimit: func(l RateLimit) { limits = append(limits, l) },
+	})
+
+	start := clock.Now()
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 1 || limits[0].Remaining != 0 || !limits[0].Reset.Equal(start.Add(3*time.Second)) {
+		t.Fatalf("Expected the parsed rate limit headers, got %+v", limits)
+	}
+	if len(clock.delays) != 0 {
+		t.Fatalf("Expected no wait before the first request, got %v", clock.delays)
+	}
+
+	remaining = "100"
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.delays) != 1 || clock.delays[0] != 3*time.Second {
+		t.Fatalf("Expected a wait until the reset when no requests remain, got %v", clock.delays)
+	}
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.delays) != 1 {
+		t.Errorf("Expected no further wait once requests remain, got %v", clock.delays)
+	}
+}
+
+func TestRateLimitThreshold(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "100")
+		w.Header().Set("X-RateLimit-Reset", "3")
+		acceptAll(t, w, req)
+	}))
+	defer server.Close()
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{
+		Host:               server.URL,
+		Clock:              clock,
+		Limiter:            SharedLimiter(),
+		RateLimitThreshold: 100,
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(clock.delays) != 1 || clock.delays[0] != 3*time.Second {
+		t.Errorf("Expected a wait until the reset at the threshold, got %v", clock.delays)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	header := http.Header{}
+	if _, ok := parseRateLimit(header, now); ok {
+		t.Error("Expected missing headers to be ignored")
+	}
+	header.Set("X-RateLimit-Remaining", "5")
+	header.Set("X-RateLimit-Reset", "1700000060")
+	limit, ok := parseRateLimit(header, now)
+	if !ok || limit.Remaining != 5 || !limit.Reset.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected an absolute reset, got %+v", limit)
+	}
+}
+
+func TestRateLimitPauseSharedByLimiter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		remaining := "100"
+		if atomic.AddInt32(&requests, 1) == 1 {
+			remaining = "0"
+		}
+		w.Header().Set("X-RateLimit-Remaining", remaining)
+		w.Header().Set("X-RateLimit-Reset", "3")
+		acceptAll(t, w, req)
+	}))
+	defer server.Close()
+	clock := newFakeClock()
+	limiter := SharedLimiter()
+	first := NewPushClient(&ClientConfig{Host: server.URL, Clock: clock, Limiter: limiter})
+	second := NewPushClient(&ClientConfig{Host: server.URL, Clock: clock, Limiter: limiter})
+	other := NewPushClient(&ClientConfig{Host: server.URL, Clock: clock, Limiter: SharedLimiter()})
+
+	if _, err := first.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.delays) != 0 {
+		t.Fatalf("Expected a client with its own limiter not to pause, got %v", clock.delays)
+	}
+	if _, err := second.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.delays) != 1 || clock.delays[0] != 3*time.Second {
+		t.Errorf("Expected the other client sharing the limiter to wait until the reset, got %v", clock.delays)
+	}
+}
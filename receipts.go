@@ -0,0 +1,350 @@
+package expo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// MaxReceiptIDsPerRequest is the maximum number of receipt IDs Expo accepts in a single request
+const MaxReceiptIDsPerRequest = 1000
+
+const (
+	// DefaultPollInterval is the default delay before receipts are polled again
+	DefaultPollInterval = 5 * time.Second
+	// DefaultMaxPollInterval is the default upper bound on the delay between polls
+	DefaultMaxPollInterval = time.Minute
+	// DefaultPollMultiplier is the default growth factor of the delay between polls
+	DefaultPollMultiplier = 2
+	// DefaultPollJitter is the jitter used when PollReceipts is given no config
+	DefaultPollJitter = 0.2
+)
+
+// PollConfig controls the delay between rounds of PollReceipts.
+// Zero values for Interval, MaxInterval and Multiplier use the defaults above;
+// a zero Jitter disables jitter.
+type PollConfig struct {
+	Interval    time.Duration // Delay before the first re-poll
+	MaxInterval time.Duration // Upper bound on the delay before jitter is applied
+	Multiplier  float64       // Growth factor applied to the delay after each round
+	Jitter      float64       // Fraction of each delay randomized in either direction, between 0 and 1
+	Rand        *rand.Rand    // Source of jitter, defaulting to a time-seeded source; used under a lock, so a config may be shared
+}
+
+// withDefaults returns a copy of the config with unset fields defaulted
+func (p *PollConfig) withDefaults() PollConfig {
+	cfg := PollConfig{Jitter: DefaultPollJitter}
+	if p != nil {
+		cfg = *p
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultPollInterval
+	}
+	if cfg.MaxInterval <= 0 {
+		cfg.MaxInterval = DefaultMaxPollInterval
+	}
+	if cfg.Multiplier <= 0 {
+		cfg.Multiplier = DefaultPollMultiplier
+	}
+	return cfg
+}
+
+// jittered returns d randomized by up to the configured jitter fraction in either direction
+func (p *PollConfig) jittered(d time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return d
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	source := p.Rand
+	if source == nil {
+		source = jitterRand
+	}
+	offset := (source.Float64()*2 - 1) * p.Jitter * float64(d)
+	return d + time.Duration(offset)
+}
+
+// PushReceipt is the delivery receipt for a single push notification.
+// https://docs.expo.dev/push-notifications/sending-notifications/#push-receipt-response-format
+// A successful delivery:
+//
+//	{'status': 'ok'}
+//
+// A delivery failure:
+//
+//	{'status': 'error',
+//	 'message': 'The Apple Push Notification service failed to send the notification',
+//	 'details': {'error': 'DeviceNotRegistered'}}
+type PushReceipt struct {
+	Status  string                     `json:"status"`
+	Message string                     `json:"message"`
+	Details map[string]json.RawMessage `json:"details"`
+
+	successStatuses []string                                       // Statuses counted as success, set by the client from ClientConfig.SuccessStatuses
+	classify        func(details map[string]json.RawMessage) error // Custom classifier, set by the client from ClientConfig.ClassifyError
+}
+
+// ValidateReceipt returns an error if the receipt indicates that delivery failed.
+// The returned errors are the same types returned by PushResponse.ValidateResponse.
+func (r *PushReceipt) ValidateReceipt() error {
+	response := &PushResponse{
+		Status:          r.Status,
+		Message:         r.Message,
+		Details:         r.Details,
+		successStatuses: r.successStatuses,
+		classify:        r.classify,
+	}
+	return response.ValidateResponse()
+}
+
+// ShouldRemoveToken reports whether the receipt shows the recipient's token is no
+// longer registered and should be removed from storage.
+func (r PushReceipt) ShouldRemoveToken() bool {
+	_, ok := r.ValidateReceipt().(*DeviceNotRegisteredError)
+	return ok
+}
+
+// ReceiptSummary counts receipts by outcome, for a quick health snapshot after polling
+type ReceiptSummary struct {
+	OK           int            // Receipts of delivered notifications
+	ErrorsByCode map[string]int // Failed receipts by error code, such as DeviceNotRegistered; "" for those without a code
+	Unresolved   int            // Receipts without a status, such as zero placeholders for IDs still pending
+}
+
+// SummarizeReceipts counts receipts by outcome, classifying each with ValidateReceipt
+func SummarizeReceipts(receipts map[string]PushReceipt) ReceiptSummary {
+	summary := ReceiptSummary{ErrorsByCode: map[string]int{}}
+	for _, receipt := range receipts {
+		err := receipt.ValidateReceipt()
+		var coded interface{ Code() string }
+		switch {
+		case err == nil:
+			summary.OK++
+		case receipt.Status == "":
+			summary.Unresolved++
+		case errors.As(err, &coded):
+			summary.ErrorsByCode[coded.Code()]++
+		default:
+			summary.ErrorsByCode[""]++
+		}
+	}
+	return summary
+}
+
+// receiptsRequest is the body of an Expo getReceipts HTTP request
+type receiptsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// receiptsResponse is the HTTP response returned from an Expo getReceipts HTTP request
+type receiptsResponse struct {
+	Data   map[string]PushReceipt `json:"data"`
+	Errors []map[string]string    `json:"errors"`
+}
+
+// ReceiptIDsToPoll returns the receipt IDs of the successful responses, in
+// order, ready to pass to GetPushNotificationReceipts. Failed responses and
+// those without an ID have no receipt to poll.
+func ReceiptIDsToPoll(responses []PushResponse) []string {
+	var ids []string
+	for _, r := range responses {
+		if r.ID != "" && r.isSuccess() {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
+// GetPushNotificationReceipts fetches the receipts for the given receipt IDs.
+// Duplicate and empty IDs are removed and the rest are requested in chunks of
+// MaxReceiptIDsPerRequest. In strict mode an empty ID is an error instead.
+// Receipts which are not yet available are absent from the returned map.
+// @param ids: receipt IDs returned in PushResponse.ID
+// @return a map of receipt ID to PushReceipt, including those of successful chunks if any failed
+// @return error joining the errors of any failed chunks
+func (c *PushClient) GetPushNotificationReceipts(ctx context.Context, ids []string) (map[string]PushReceipt, error) {
+	cache := receiptCache{}
+	err := c.streamReceipts(ctx, ids, cache, nil)
+	return cache, err
+}
+
+// GetReceiptsStream fetches receipts in chunks of MaxReceiptIDsPerRequest and
+// invokes onReceipt for each resolved receipt as each chunk arrives, rather than
+// buffering the whole result set. Each ID is requested and reported at most once.
+// A failed chunk does not stop the remaining chunks from being fetched; the
+// errors of all failed chunks are joined in the returned error.
+func (c *PushClient) GetReceiptsStream(ctx context.Context, ids []string, onReceipt func(id string, r PushReceipt)) error {
+	return c.streamReceipts(ctx, ids, receiptCache{}, onReceipt)
+}
+
+// receiptCache holds the receipts resolved during a single call, so that
+// resolved IDs are never requested again within it
+type receiptCache map[string]PushReceipt
+
+// pending returns the IDs which haven't resolved, without duplicates or empty IDs
+func (rc receiptCache) pending(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	var pending []string
+	for _, id := range ids {
+		if _, ok := rc[id]; ok || seen[id] || id == "" {
+			continue
+		}
+		seen[id] = true
+		pending = append(pending, id)
+	}
+	return pending
+}
+
+// streamReceipts requests the receipts of the IDs missing from cache, adding
+// each resolved receipt to cache and passing it to onReceipt if set
+func (c *PushClient) streamReceipts(ctx context.Context, ids []string, cache receiptCache, onReceipt func(id string, r PushReceipt)) error {
+	if c.strict {
+		for i, id := range ids {
+			if id == "" {
+				return &ReceiptIDError{Index: i, Err: ErrEmptyReceiptID}
+			}
+		}
+	}
+	ids = cache.pending(ids)
+	var errs []error
+	for start := 0; start < len(ids); start += MaxReceiptIDsPerRequest {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		end := start + MaxReceiptIDsPerRequest
+		if end > len(ids) {
+			end = len(ids)
+		}
+		receipts, err := c.getReceiptsInternal(ctx, ids[start:end])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("receipts chunk %d: %w", start/MaxReceiptIDsPerRequest, err))
+			continue
+		}
+		for _, id := range ids[start:end] {
+			if receipt, ok := receipts[id]; ok {
+				receipt.successStatuses = c.successStatuses
+				receipt.classify = c.classify
+				cache[id] = receipt
+				if onReceipt != nil {
+					onReceipt(id, receipt)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *PushClient) getReceiptsInternal(ctx context.Context, ids []string) (map[string]PushReceipt, error) {
+	// Build request
+	body, err := json.Marshal(&receiptsRequest{IDs: ids})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.requestContext(ctx, 0)
+	defer cancel()
+	req, err := c.buildRequest(ctx, c.receiptsEndpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Check that we didn't receive an invalid response
+	err = checkStatus(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var r *receiptsResponse
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		// The response isn't json
+		return nil, err
+	}
+	return r.receipts(resp)
+}
+
+// receipts returns the receipts of a decoded response, or an error if the
+// response reports errors or has no data
+func (r *receiptsResponse) receipts(resp *http.Response) (map[string]PushReceipt, error) {
+	// If there are errors with the entire request, raise an error now.
+	if r.Errors != nil {
+		return nil, NewPushServerError("Invalid server response", resp, nil, r.Errors)
+	}
+	// We expect the response to have a 'data' field with the receipts.
+	if r.Data == nil {
+		return nil, NewPushServerError("Invalid server response", resp, nil, nil)
+	}
+	return r.Data, nil
+}
+
+// ParseReceipt decodes a single receipt, for callers fetching receipts with
+// their own HTTP client. Use ValidateReceipt on the result to classify it.
+func ParseReceipt(raw json.RawMessage) (PushReceipt, error) {
+	var receipt PushReceipt
+	if err := json.Unmarshal(raw, &receipt); err != nil {
+		return PushReceipt{}, err
+	}
+	return receipt, nil
+}
+
+// ParseReceipts decodes the body of a getReceipts response into a map of
+// receipt ID to PushReceipt, for callers fetching receipts with their own
+// HTTP client. Request level errors are returned as a *PushServerError.
+func ParseReceipts(raw json.RawMessage) (map[string]PushReceipt, error) {
+	var r *receiptsResponse
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, NewPushServerError("Invalid server response", nil, nil, nil)
+	}
+	return r.receipts(nil)
+}
+
+// PollReceipts fetches receipts until every ID has resolved, waiting between
+// rounds with a jittered, exponentially growing delay so that many instances
+// polling at once don't do so in lockstep. Receipts are cached for the
+// duration of the call, so only unresolved IDs are requested in each round.
+// A nil config uses the defaults with DefaultPollJitter.
+// Polling stops with the receipts resolved so far when ctx is done, or with
+// context.DeadlineExceeded as soon as the next wait would pass ctx's deadline.
+func (c *PushClient) PollReceipts(ctx context.Context, ids []string, config *PollConfig) (map[string]PushReceipt, error) {
+	cfg := config.withDefaults()
+	cache := receiptCache{}
+	interval := cfg.Interval
+	for {
+		err := c.streamReceipts(ctx, ids, cache, nil)
+		if err != nil {
+			return cache, err
+		}
+		if len(cache.pending(ids)) == 0 {
+			return cache, nil
+		}
+
+		delay := cfg.jittered(interval)
+		// Context deadlines are in wall time, whatever the client's clock
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return cache, context.DeadlineExceeded
+		}
+		select {
+		case <-ctx.Done():
+			return cache, ctx.Err()
+		case <-c.clock.After(delay):
+		}
+		interval = time.Duration(float64(interval) * cfg.Multiplier)
+		if interval > cfg.MaxInterval {
+			interval = cfg.MaxInterval
+		}
+	}
+}
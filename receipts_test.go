@@ -0,0 +1,461 @@
+package expo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newReceiptsServer returns a server which resolves every requested ID to an
+// ok receipt, failing the request numbers listed in failRequests with a 500.
+func newReceiptsServer(t *testing.T, requests *int, failRequests ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/--/api/v2/push/getReceipts" {
+			t.Errorf("Unexpected path %s", req.URL.Path)
+		}
+		*requests += 1
+		for _, n := range failRequests {
+			if n == *requests {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		var body receiptsRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Error("Invalid request body")
+		}
+		data := map[string]PushReceipt{}
+		for _, id := range body.IDs {
+			data[id] = PushReceipt{Status: SuccessStatus}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func receiptIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("receipt-%d", i)
+	}
+	return ids
+}
+
+func TestGetPushNotificationReceipts(t *testing.T) {
+	var requests int
+	server := newReceiptsServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	receipts, err := client.GetPushNotificationReceipts(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 || receipts["a"].Status != SuccessStatus {
+		t.Error("Didn't return requested receipts")
+	}
+}
+
+func TestGetPushNotificationReceiptsChunks(t *testing.T) {
+	var requests int
+	server := newReceiptsServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	ids := receiptIDs(2500)
+	receipts, err := client.GetPushNotificationReceipts(context.Background(), append(ids, ids[:10]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+	if len(receipts) != len(ids) {
+		t.Errorf("Expected %d receipts, got %d", len(ids), len(receipts))
+	}
+}
+
+func TestGetPushNotificationReceiptsPartialFailure(t *testing.T) {
+	var requests int
+	server := newReceiptsServer(t, &requests, 3)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	receipts, err := client.GetPushNotificationReceipts(context.Background(), receiptIDs(2500))
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("Expected the failed chunk's error, got %v", err)
+	}
+	if len(receipts) != 2*MaxReceiptIDsPerRequest {
+		t.Errorf("Expected receipts from the successful chunks, got %d", len(receipts))
+	}
+}
+
+func TestGetReceiptsStreamMultipleChunks(t *testing.T) {
+	var requests int
+	server := newReceiptsServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	ids := receiptIDs(2*MaxReceiptIDsPerRequest + 1)
+	seen := map[string]bool{}
+	err := client.GetReceiptsStream(context.Background(), ids, func(id string, r PushReceipt) {
+		seen[id] = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+	if len(seen) != len(ids) {
+		t.Errorf("Expected %d receipts, got %d", len(ids), len(seen))
+	}
+}
+
+func TestGetReceiptsStreamChunkError(t *testing.T) {
+	var requests int
+	server := newReceiptsServer(t, &requests, 2)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	ids := receiptIDs(2*MaxReceiptIDsPerRequest + 1)
+	var count int
+	err := client.GetReceiptsStream(context.Background(), ids, func(id string, r PushReceipt) {
+		count += 1
+	})
+	if err == nil {
+		t.Fatal("Expected an error for the failed chunk")
+	}
+	if requests != 3 {
+		t.Errorf("Expected the remaining chunks to be fetched, got %d requests", requests)
+	}
+	if count != MaxReceiptIDsPerRequest+1 {
+		t.Errorf("Expected receipts from the successful chunks, got %d", count)
+	}
+}
+
+// newPendingReceiptsServer returns a server which only resolves receipts from
+// the resolveOn-th request onwards, counting the IDs requested in each round.
+func newPendingReceiptsServer(t *testing.T, resolveOn int, rounds *[]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body receiptsRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Error("Invalid request body")
+		}
+		*rounds = append(*rounds, len(body.IDs))
+		data := map[string]PushReceipt{}
+		if len(*rounds) >= resolveOn {
+			for _, id := range body.IDs {
+				data[id] = PushReceipt{Status: SuccessStatus}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestPollReceiptsJitteredIntervals(t *testing.T) {
+	var rounds []int
+	server := newPendingReceiptsServer(t, 5, &rounds)
+	defer server.Close()
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{Host: server.URL, Clock: clock})
+
+	interval := time.Second
+	receipts, err := client.PollReceipts(context.Background(), []string{"a", "b"}, &PollConfig{
+		Interval:   interval,
+		Multiplier: 1,
+		Jitter:     0.5,
+		Rand:       rand.New(rand.NewSource(1)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 {
+		t.Errorf("Expected 2 receipts, got %d", len(receipts))
+	}
+	if len(clock.delays) != 4 {
+		t.Fatalf("Expected 4 waits, got %d", len(clock.delays))
+	}
+	distinct := map[time.Duration]bool{}
+	for _, d := range clock.delays {
+		if d < interval/2 || d > interval*3/2 {
+			t.Errorf("Delay %s outside of jittered range", d)
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < 2 {
+		t.Error("Expected jitter to vary the delays")
+	}
+}
+
+func TestPollConfigSharedRand(t *testing.T) {
+	// Concurrent polls, such as PublishAndTrack's per access token, share one config and source
+	config := (&PollConfig{Interval: time.Second, Jitter: 0.5, Rand: rand.New(rand.NewSource(1))}).withDefaults()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if d := config.jittered(time.Second); d < time.Second/2 || d > time.Second*3/2 {
+					t.Errorf("Delay %s outside of jittered range", d)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPollReceiptsStopsBeforeDeadline(t *testing.T) {
+	var rounds []int
+	server := newPendingReceiptsServer(t, 100, &rounds)
+	defer server.Close()
+	// The deadline is in wall time, so a clock far from it mustn't matter
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	client := NewPushClient(&ClientConfig{Host: server.URL, Clock: clock})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	_, err := client.PollReceipts(ctx, []string{"a"}, &PollConfig{Interval: time.Second})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	// The second wait of 2s would pass the deadline
+	if len(rounds) != 2 || len(clock.delays) != 1 {
+		t.Errorf("Expected 2 rounds and 1 wait, got %d and %d", len(rounds), len(clock.delays))
+	}
+}
+
+func TestPollReceiptsRequestsOnlyPending(t *testing.T) {
+	requested := [][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body receiptsRequest
+		json.NewDecoder(req.Body).Decode(&body)
+		requested = append(requested, body.IDs)
+		data := map[string]PushReceipt{}
+		for _, id := range body.IDs {
+			if id == "a" || len(requested) > 1 {
+				data[id] = PushReceipt{Status: SuccessStatus}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, Clock: newFakeClock()})
+
+	if _, err := client.PollReceipts(context.Background(), []string{"a", "b"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 2 || len(requested[1]) != 1 || requested[1][0] != "b" {
+		t.Errorf("Expected only the pending ID to be re-requested, got %v", requested)
+	}
+}
+
+func TestReceiptShouldRemoveToken(t *testing.T) {
+	if (PushReceipt{Status: SuccessStatus}).ShouldRemoveToken() {
+		t.Error("Flagged a successful receipt for removal")
+	}
+	unregistered := PushReceipt{
+		Status:  "error",
+		Details: map[string]json.RawMessage{"error": []byte("DeviceNotRegistered")},
+	}
+	if !unregistered.ShouldRemoveToken() {
+		t.Error("Didn't flag an unregistered device for removal")
+	}
+}
+
+func TestParseReceipt(t *testing.T) {
+	raw := json.RawMessage(`{
+		"status": "error",
+		"message": "\"ExponentPushToken[xxxxxxxxxxxxxxxxxxxxxx]\" is not a registered push notification recipient",
+		"details": {"error": "DeviceNotRegistered"}
+	}`)
+	receipt, err := ParseReceipt(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := receipt.ValidateReceipt().(*DeviceNotRegisteredError); !ok {
+		t.Error("Didn't classify the parsed receipt")
+	}
+	if _, err := ParseReceipt(json.RawMessage(`not json`)); err == nil {
+		t.Error("Expected an error for invalid JSON")
+	}
+}
+
+func TestParseReceipts(t *testing.T) {
+	raw := json.RawMessage(`{
+		"data": {
+			"XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX": {"status": "ok"},
+			"ZZZZZZZZ-ZZZZ-ZZZZ-ZZZZ-ZZZZZZZZZZZZ": {
+				"status": "error",
+				"message": "The Apple Push Notification service failed to send the notification",
+				"details": {"error": "MessageRateExceeded"}
+			}
+		}
+	}`)
+	receipts, err := ParseReceipts(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("Expected 2 receipts, got %d", len(receipts))
+	}
+	ok := receipts["XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX"]
+	if ok.ValidateReceipt() != nil {
+		t.Error("Expected an ok receipt")
+	}
+	failed := receipts["ZZZZZZZZ-ZZZZ-ZZZZ-ZZZZ-ZZZZZZZZZZZZ"]
+	if _, ok := failed.ValidateReceipt().(*MessageRateExceededError); !ok {
+		t.Error("Didn't classify the failed receipt")
+	}
+}
+
+func TestParseReceiptsServerError(t *testing.T) {
+	raw := json.RawMessage(`{"errors": [{"code": "API_ERROR", "message": "Invalid receipt IDs"}]}`)
+	_, err := ParseReceipts(raw)
+	typed, ok := err.(*PushServerError)
+	if !ok {
+		t.Fatalf("Expected a server error, got %v", err)
+	}
+	if len(typed.Errors) != 1 || typed.Errors[0]["code"] != "API_ERROR" {
+		t.Error("Didn't return the request errors")
+	}
+}
+
+func TestGetReceiptsStreamSkipsResolvedIDs(t *testing.T) {
+	var requests int
+	server := newReceiptsServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	ids := receiptIDs(MaxReceiptIDsPerRequest)
+	var count int
+	err := client.GetReceiptsStream(context.Background(), append(ids, ids[:10]...), func(id string, r PushReceipt) {
+		count += 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected resolved IDs not to be requested again, got %d requests", requests)
+	}
+	if count != len(ids) {
+		t.Errorf("Expected each receipt to be reported once, got %d", count)
+	}
+}
+
+func TestPollReceiptsCachesResolved(t *testing.T) {
+	var requested []string
+	var rounds int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body receiptsRequest
+		json.NewDecoder(req.Body).Decode(&body)
+		rounds += 1
+		requested = append(requested, body.IDs...)
+		data := map[string]PushReceipt{}
+		for _, id := range body.IDs {
+			// Resolve one more ID per round
+			if id < fmt.Sprintf("receipt-%d", rounds) {
+				data[id] = PushReceipt{Status: SuccessStatus}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, Clock: newFakeClock()})
+
+	receipts, err := client.PollReceipts(context.Background(), receiptIDs(3), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 3 {
+		t.Errorf("Expected 3 receipts, got %d", len(receipts))
+	}
+	seen := map[string]int{}
+	for _, id := range requested {
+		seen[id] += 1
+	}
+	// receipt-0 resolves in round 1, receipt-1 in round 2, receipt-2 in round 3
+	if seen["receipt-0"] != 1 || seen["receipt-1"] != 2 || seen["receipt-2"] != 3 {
+		t.Errorf("Expected resolved IDs to drop out of later rounds, got %v", seen)
+	}
+}
+
+func TestGetPushNotificationReceiptsFiltersIDs(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body receiptsRequest
+		json.NewDecoder(req.Body).Decode(&body)
+		requested = append(requested, body.IDs...)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]PushReceipt{}})
+	}))
+	defer server.Close()
+
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+	if _, err := client.GetPushNotificationReceipts(context.Background(), []string{"a", "", "b", "a", ""}); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 2 || requested[0] != "a" || requested[1] != "b" {
+		t.Errorf("Expected deduplicated, non-empty IDs, got %q", requested)
+	}
+
+	requested = nil
+	strict := NewPushClient(&ClientConfig{Host: server.URL, Strict: true})
+	_, err := strict.GetPushNotificationReceipts(context.Background(), []string{"a", ""})
+	typed, ok := err.(*ReceiptIDError)
+	if !ok || typed.Index != 1 || !errors.Is(err, ErrEmptyReceiptID) {
+		t.Errorf("Expected an empty ID error, got %v", err)
+	}
+	if msg := err.Error(); msg != "receipt ID 1 is empty" {
+		t.Errorf("Expected the error to name the receipt ID, got %q", msg)
+	}
+	if len(requested) != 0 {
+		t.Error("Expected no request in strict mode with an empty ID")
+	}
+}
+
+func TestReceiptIDsToPoll(t *testing.T) {
+	responses := []PushResponse{
+		{ID: "a", Status: SuccessStatus},
+		{ID: "b", Status: "error", Details: map[string]json.RawMessage{"error": []byte(`"DeviceNotRegistered"`)}},
+		{Status: SuccessStatus},
+		{ID: "c", Status: SuccessStatus},
+		{ID: "d", Status: "queued", successStatuses: []string{SuccessStatus, "queued"}},
+	}
+	ids := ReceiptIDsToPoll(responses)
+	if len(ids) != 3 || ids[0] != "a" || ids[1] != "c" || ids[2] != "d" {
+		t.Errorf("Expected the IDs of the successful responses, got %v", ids)
+	}
+}
+
+func TestSummarizeReceipts(t *testing.T) {
+	failure := func(code string) map[string]json.RawMessage {
+		return map[string]json.RawMessage{"error": []byte(`"` + code + `"`)}
+	}
+	receipts := map[string]PushReceipt{
+		"a": {Status: SuccessStatus},
+		"b": {Status: SuccessStatus},
+		"c": {Status: "error", Details: failure(ErrorDeviceNotRegistered)},
+		"d": {Status: "error", Details: failure(ErrorDeviceNotRegistered)},
+		"e": {Status: "error", Details: failure(ErrorMessageRateExceeded)},
+		"f": {Status: "error", Message: "no details"},
+		"g": {},
+	}
+	summary := SummarizeReceipts(receipts)
+	expected := map[string]int{ErrorDeviceNotRegistered: 2, ErrorMessageRateExceeded: 1, "": 1}
+	if summary.OK != 2 || summary.Unresolved != 1 || len(summary.ErrorsByCode) != len(expected) {
+		t.Fatalf("Unexpected summary %+v", summary)
+	}
+	for code, n := range expected {
+		if summary.ErrorsByCode[code] != n {
+			t.Errorf("Expected %d %q errors, got %d", n, code, summary.ErrorsByCode[code])
+		}
+	}
+}
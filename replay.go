@@ -0,0 +1,38 @@
+package expo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// SerializeBatch encodes messages, typically ChunkError.Messages, as a gzipped
+// blob which can be persisted to disk or a queue and later sent with ReplayBatch.
+// The blob holds the messages' plain JSON, so Metadata is not preserved; wire
+// encodings such as StringifyData and BatchMarshaler apply when it is replayed.
+func (c *PushClient) SerializeBatch(messages []PushMessage) ([]byte, error) {
+	body, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	return gzipBody(body)
+}
+
+// ReplayBatch decodes a blob produced by SerializeBatch and publishes its messages
+func (c *PushClient) ReplayBatch(ctx context.Context, blob []byte) ([]PushResponse, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	var messages []PushMessage
+	if err := json.Unmarshal(body, &messages); err != nil {
+		return nil, err
+	}
+	return c.PublishMultiple(ctx, messages)
+}
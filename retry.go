@@ -0,0 +1,313 @@
+package expo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultInitialBackoff is the default delay before the first retry
+	DefaultInitialBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff is the default upper bound on the delay between retries
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// ErrRetryBudgetExhausted is returned when a PublishMultiple call has used up its retry budget
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// Jitter chooses how the delay before a retry is randomized, so that many
+// instances backing off after a shared 429 don't retry in lockstep
+type Jitter int
+
+const (
+	// EqualJitter waits half the backoff plus a random part of the other half.
+	// It is the default.
+	EqualJitter Jitter = iota
+	// FullJitter waits a random delay between zero and the backoff
+	FullJitter
+	// NoJitter waits exactly the backoff
+	NoJitter
+)
+
+// RetryConfig controls how failed push requests are retried.
+// Network errors, 429 and 5xx responses are retried with exponential backoff
+// unless another Backoff is given, randomized by Jitter.
+type RetryConfig struct {
+	MaxRetries     int           // Retries of each request after its first attempt
+	InitialBackoff time.Duration // Delay before the first retry, defaults to DefaultInitialBackoff
+	MaxBackoff     time.Duration // Upper bound on the delay, defaults to DefaultMaxBackoff
+	// Budget caps the total retries across all chunks of a single
+	// PublishMultiple call, so a failing batch can't amplify traffic.
+	// Zero means no cap beyond MaxRetries.
+	Budget int
+	// Backoff, if set, chooses the delay before each retry in place of the
+	// exponential backoff configured by InitialBackoff and MaxBackoff
+	Backoff Backoff
+	// Jitter randomizes each backoff, EqualJitter by default. Delays supplied
+	// by Expo, such as Retry-After, are used as they are.
+	Jitter Jitter
+	// Rand is the source of jitter, defaulting to a time-seeded source.
+	// Set it to a seeded source for deterministic delays in tests.
+	Rand *rand.Rand
+}
+
+var (
+	// jitterMu guards the jitter sources, since a *rand.Rand isn't safe for concurrent use
+	jitterMu sync.Mutex
+	// jitterRand is the jitter source of configs without a Rand
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jittered randomizes a backoff delay according to the config's Jitter
+func (r *RetryConfig) jittered(d time.Duration) time.Duration {
+	if r.Jitter == NoJitter || d <= 0 {
+		return d
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	source := r.Rand
+	if source == nil {
+		source = jitterRand
+	}
+	if r.Jitter == FullJitter {
+		return time.Duration(source.Int63n(int64(d) + 1))
+	}
+	half := d / 2
+	return half + time.Duration(source.Int63n(int64(d-half)+1))
+}
+
+// backoff returns the delay before the given retry, counting from zero
+func (r *RetryConfig) backoff(retry int) time.Duration {
+	if r.Backoff != nil {
+		return r.Backoff.Next(retry)
+	}
+	return ExponentialBackoff{Initial: r.InitialBackoff, Max: r.MaxBackoff}.Next(retry)
+}
+
+// Backoff chooses the delay before each retry
+type Backoff interface {
+	// Next returns the delay before the given retry, counting from zero
+	Next(attempt int) time.Duration
+}
+
+// BackoffSchedule returns the delays b waits before each of the first
+// attempts retries, to preview a strategy or assert on it in tests.
+// Backoff is an interface, so this is a function rather than a method.
+func BackoffSchedule(b Backoff, attempts int) []time.Duration {
+	schedule := make([]time.Duration, attempts)
+	for i := range schedule {
+		schedule[i] = b.Next(i)
+	}
+	return schedule
+}
+
+// Schedule returns the delays before each of the config's MaxRetries retries,
+// before jitter and not counting server supplied Retry-After hints. With
+// jitter, they are the upper bounds of the actual delays.
+func (r *RetryConfig) Schedule() []time.Duration {
+	schedule := make([]time.Duration, r.MaxRetries)
+	for i := range schedule {
+		schedule[i] = r.backoff(i)
+	}
+	return schedule
+}
+
+// ExponentialBackoff doubles the delay after each retry, up to Max
+type ExponentialBackoff struct {
+	Initial time.Duration // Delay before the first retry, defaults to DefaultInitialBackoff
+	Max     time.Duration // Upper bound on the delay, defaults to DefaultMaxBackoff
+}
+
+// Next returns Initial doubled attempt times, capped at Max
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	initial := b.Initial
+	if initial <= 0 {
+		initial = DefaultInitialBackoff
+	}
+	max := b.Max
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	delay := initial
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// ConstantBackoff waits the same delay before every retry
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+// Next returns Delay
+func (b ConstantBackoff) Next(attempt int) time.Duration {
+	return b.Delay
+}
+
+// retryBudget counts the retries remaining for one PublishMultiple call
+type retryBudget struct {
+	limited   bool
+	remaining int64
+}
+
+func newRetryBudget(config *RetryConfig) *retryBudget {
+	if config == nil || config.Budget <= 0 {
+		return &retryBudget{}
+	}
+	return &retryBudget{limited: true, remaining: int64(config.Budget)}
+}
+
+// take claims a retry, returning false if none remain
+func (b *retryBudget) take() bool {
+	if !b.limited {
+		return true
+	}
+	return atomic.AddInt64(&b.remaining, -1) >= 0
+}
+
+// isRetryable reports whether a failed request may succeed if sent again
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// retryDelay returns the delay before the given retry, preferring a server
+// supplied Retry-After header or rate limit hint
+func (c *PushClient) retryDelay(retry int, err error) time.Duration {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+	var rateErr *MessageRateExceededError
+	if errors.As(err, &rateErr) {
+		if d := rateErr.retryAfterAt(c.clock.Now()); d > 0 {
+			return d
+		}
+	}
+	return c.retry.jittered(c.retry.backoff(retry))
+}
+
+// withRetries calls send until it succeeds, fails with a non-retryable error,
+// or runs out of retries from the config or budget. Failures caused by ctx
+// are returned as a *ContextError for the given chunk.
+func (c *PushClient) withRetries(ctx context.Context, b *batch, chunk int, send func() ([]PushResponse, error)) ([]PushResponse, error) {
+	for retry := 0; ; retry++ {
+		responses, err := send()
+		if err != nil && ctx.Err() != nil {
+			return nil, &ContextError{Chunk: chunk, Attempt: retry + 1, Err: ctx.Err()}
+		}
+		if err == nil || c.retry == nil || retry >= c.retry.MaxRetries || !isRetryable(ctx, err) {
+			return responses, err
+		}
+		if !b.budget.take() {
+			return nil, fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, err)
+		}
+		atomic.AddInt64(&b.retries, 1)
+		delay := c.retryDelay(retry, err)
+		c.logf("batch %s: retrying in %s after: %v", b.id, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, &ContextError{Chunk: chunk, Attempt: retry + 1, Err: ctx.Err()}
+		case <-c.clock.After(delay):
+		}
+	}
+}
+
+// retryRateLimited resends the notifications of a chunk which were rejected
+// with MessageRateExceeded, replacing their responses in place, until they
+// succeed or the retries run out. Waits honor the hint in the response details.
+func (c *PushClient) retryRateLimited(ctx context.Context, b *batch, responses []PushResponse) {
+	if c.retry == nil {
+		return
+	}
+	for retry := 0; retry < c.retry.MaxRetries; retry++ {
+		var indices []int
+		var messages []PushMessage
+		var delay time.Duration
+		for i, r := range responses {
+			var rateErr *MessageRateExceededError
+			if errors.As(r.ValidateResponse(), &rateErr) {
+				indices = append(indices, i)
+				messages = append(messages, r.PushMessage)
+				if d := c.retryDelay(retry, rateErr); d > delay {
+					delay = d
+				}
+			}
+		}
+		if len(indices) == 0 || !b.budget.take() {
+			return
+		}
+		atomic.AddInt64(&b.retries, 1)
+		c.logf("batch %s: resending %d rate limited notifications in %s", b.id, len(indices), delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(delay):
+		}
+		retried, err := c.publishChunk(ctx, b, messages)
+		if err != nil {
+			// Keep the rate limited responses
+			return
+		}
+		for j, i := range indices {
+			responses[i] = retried[j]
+		}
+	}
+}
+
+// ResendFailed republishes the messages of the failed responses which may
+// succeed if sent again, using the PushMessage attached to each response, and
+// returns the new responses. Failures which won't succeed on a resend, such as
+// DeviceNotRegistered, MessageTooBig, MismatchSenderId and invalid
+// credentials, are skipped, as are successful responses.
+func (c *PushClient) ResendFailed(ctx context.Context, responses []PushResponse, opts ...PublishOption) ([]PushResponse, error) {
+	var messages []PushMessage
+	for _, r := range responses {
+		if err := r.ValidateResponse(); err != nil && isResendable(err) {
+			if message := r.RetryMessage(); len(message.To) > 0 {
+				messages = append(messages, message)
+			}
+		}
+	}
+	return c.PublishMultiple(ctx, messages, opts...)
+}
+
+// RetryMessage returns the message which produced the response, addressed to
+// the response's token only, ready to publish again. The zero PushMessage is
+// returned if the response has no message attached, i.e. wasn't returned by
+// a publish call, or isn't for exactly one token.
+func (r PushResponse) RetryMessage() PushMessage {
+	if len(r.PushMessage.To) != 1 {
+		return PushMessage{}
+	}
+	message := r.PushMessage
+	message.To = []string{r.PushMessage.To[0]}
+	return message
+}
+
+// isResendable reports whether a notification which failed with err may succeed if sent again
+func isResendable(err error) bool {
+	switch err.(type) {
+	case *DeviceNotRegisteredError, *MessageTooBigError, *MismatchSenderIdError, *InvalidCredentialsError:
+		return false
+	}
+	return true
+}
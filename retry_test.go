@@ -0,0 +1,395 @@
+package expo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyServer returns a server which fails the first failures requests with
+// a 503 before accepting every notification.
+func newFlakyServer(t *testing.T, failures int32, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		acceptAll(t, w, req)
+	}))
+}
+
+func TestRetryRecoversFromFlakyServer(t *testing.T) {
+	var requests int32
+	server := newFlakyServer(t, 2, &requests)
+	defer server.Close()
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{
+		Host:  server.URL,
+		Clock: clock,
+		Retry: &RetryConfig{MaxRetries: 3, InitialBackoff: time.Second, Jitter: NoJitter},
+	})
+
+	responses, err := client.PublishMultiple(context.Background(), testMessages(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || requests != 3 {
+		t.Errorf("Expected success on the third attempt, got %d requests", requests)
+	}
+	if len(clock.delays) != 2 || clock.delays[0] != time.Second || clock.delays[1] != 2*time.Second {
+		t.Errorf("Expected exponential backoff delays, got %v", clock.delays)
+	}
+}
+
+func TestRetryBudgetExhausted(t *testing.T) {
+	var requests int32
+	server := newFlakyServer(t, 1000, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{
+		Host:  server.URL,
+		Clock: newFakeClock(),
+		Retry: &RetryConfig{MaxRetries: 5, Budget: 2},
+	})
+
+	_, err := client.PublishMultiple(context.Background(), testMessages(3*MaxNotificationsPerRequest))
+	if !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("Expected retry budget exhausted, got %v", err)
+	}
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Error("Expected the last request error to be wrapped")
+	}
+	// One attempt per chunk plus the two budgeted retries
+	if requests != 5 {
+		t.Errorf("Expected 5 requests, got %d", requests)
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{
+		Host:  server.URL,
+		Clock: clock,
+		Retry: &RetryConfig{MaxRetries: 1},
+	})
+
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if len(clock.delays) != 1 || clock.delays[0] != 7*time.Second {
+		t.Errorf("Expected to wait for Retry-After, got %v", clock.delays)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{
+		Host:  server.URL,
+		Clock: newFakeClock(),
+		Retry: &RetryConfig{MaxRetries: 3},
+	})
+
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if requests != 1 {
+		t.Errorf("Expected a single request, got %d", requests)
+	}
+}
+
+func TestMessageRateExceededRetryAfter(t *testing.T) {
+	response := &PushResponse{
+		Status: "error",
+		Details: map[string]json.RawMessage{
+			"error":      []byte(`"MessageRateExceeded"`),
+			"retryAfter": []byte(`3`),
+		},
+	}
+	rateErr, ok := response.ValidateResponse().(*MessageRateExceededError)
+	if !ok {
+		t.Fatal("Incorrect error type")
+	}
+	if rateErr.RetryAfter() != 3*time.Second {
+		t.Errorf("Expected a 3s hint, got %s", rateErr.RetryAfter())
+	}
+	delete(response.Details, "retryAfter")
+	if rateErr.RetryAfter() != 0 {
+		t.Error("Expected no hint without details")
+	}
+
+	now := time.Unix(1700000000, 0)
+	response.Details["expires"] = []byte(`1700000005`)
+	if d := rateErr.retryAfterAt(now); d != 5*time.Second {
+		t.Errorf("Expected a 5s hint until expires, got %s", d)
+	}
+	if d := rateErr.retryAfterAt(now.Add(time.Minute)); d != 0 {
+		t.Errorf("Expected no hint once expires has passed, got %s", d)
+	}
+}
+
+func TestRetryRateLimitedExpiresByClock(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			acceptAll(t, w, req)
+			return
+		}
+		w.Write([]byte(`{"data": [{"status": "error", "details": {"error": "MessageRateExceeded", "expires": 1700000007}}]}`))
+	}))
+	defer server.Close()
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	client := NewPushClient(&ClientConfig{Host: server.URL, Clock: clock, Retry: &RetryConfig{MaxRetries: 1}})
+
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.delays) != 1 || clock.delays[0] != 7*time.Second {
+		t.Errorf("Expected to wait until expires by the client's clock, got %v", clock.delays)
+	}
+}
+
+func TestRetryRateLimitedNotifications(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			acceptAll(t, w, req)
+			return
+		}
+		w.Write([]byte(`{"data": [
+			{"status": "ok", "id": "first"},
+			{"status": "error", "message": "Too many", "details": {"error": "MessageRateExceeded", "retryAfter": 4}}
+		]}`))
+	}))
+	defer server.Close()
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{
+		Host:  server.URL,
+		Clock: clock,
+		Retry: &RetryConfig{MaxRetries: 2},
+	})
+
+	responses, err := client.PublishMultiple(context.Background(), testMessages(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected the rate limited notification to be resent, got %d requests", requests)
+	}
+	if responses[0].ID != "first" || responses[1].ValidateResponse() != nil {
+		t.Error("Expected the resent notification's response to replace the rate limited one")
+	}
+	if responses[1].PushMessage.To[0] != "ExponentPushToken[1]" {
+		t.Error("Resent response isn't mapped to its message")
+	}
+	if len(clock.delays) != 1 || clock.delays[0] != 4*time.Second {
+		t.Errorf("Expected to wait for the details hint, got %v", clock.delays)
+	}
+}
+
+func TestContextError(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := client.PublishMultiple(ctx, testMessages(1))
+	var ctxErr *ContextError
+	if !errors.As(err, &ctxErr) || !ctxErr.Canceled() || ctxErr.DeadlineExceeded() {
+		t.Fatalf("Expected a cancellation, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) || ctxErr.Chunk != 0 || ctxErr.Attempt != 1 {
+		t.Errorf("Unexpected context error %v", ctxErr)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.PublishMultiple(ctx, testMessages(1))
+	if !errors.As(err, &ctxErr) || !ctxErr.DeadlineExceeded() || ctxErr.Canceled() {
+		t.Fatalf("Expected a deadline, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected errors.Is to match context.DeadlineExceeded")
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: time.Second, Max: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for attempt, d := range expected {
+		if got := b.Next(attempt); got != d {
+			t.Errorf("Attempt %d: expected %s, got %s", attempt, d, got)
+		}
+	}
+	if got := (ExponentialBackoff{}).Next(0); got != DefaultInitialBackoff {
+		t.Errorf("Expected the default initial backoff, got %s", got)
+	}
+}
+
+func TestConstantBackoff(t *testing.T) {
+	var requests int32
+	server := newFlakyServer(t, 3, &requests)
+	defer server.Close()
+	clock := newFakeClock()
+	client := NewPushClient(&ClientConfig{
+		Host:  server.URL,
+		Clock: clock,
+		Retry: &RetryConfig{MaxRetries: 3, Backoff: ConstantBackoff{Delay: 250 * time.Millisecond}, Jitter: NoJitter},
+	})
+
+	if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.delays) != 3 {
+		t.Fatalf("Expected 3 retries, got %v", clock.delays)
+	}
+	for _, d := range clock.delays {
+		if d != 250*time.Millisecond {
+			t.Errorf("Expected constant delays, got %v", clock.delays)
+		}
+	}
+}
+
+func TestResendFailed(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	messages := testMessages(4)
+	failure := func(code string) map[string]json.RawMessage {
+		return map[string]json.RawMessage{"error": []byte(`"` + code + `"`)}
+	}
+	responses := []PushResponse{
+		{PushMessage: messages[0], Status: SuccessStatus},
+		{PushMessage: messages[1], Status: "error", Details: failure(ErrorMessageRateExceeded)},
+		{PushMessage: messages[2], Status: "error", Details: failure(ErrorDeviceNotRegistered)},
+		{PushMessage: messages[3], Status: "error", Details: failure(ErrorProviderError)},
+	}
+	resent, err := client.ResendFailed(context.Background(), responses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 || len(resent) != 2 {
+		t.Fatalf("Expected 2 notifications resent in 1 request, got %d in %d", len(resent), requests)
+	}
+	if resent[0].PushMessage.To[0] != messages[1].To[0] || resent[1].PushMessage.To[0] != messages[3].To[0] {
+		t.Error("Expected just the retryable failures to be resent")
+	}
+}
+
+func TestRetryMessage(t *testing.T) {
+	var requests int32
+	server := newPushServer(t, &requests)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	message := PushMessage{
+		To:       []string{"ExponentPushToken[a]", "ExponentPushToken[b]"},
+		Title:    "hello",
+		Metadata: map[string]string{"user": "42"},
+	}
+	responses, err := client.PublishMultiple(context.Background(), []PushMessage{message})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := responses[1].RetryMessage()
+	if len(retry.To) != 1 || retry.To[0] != "ExponentPushToken[b]" || retry.Title != "hello" || retry.Metadata["user"] != "42" {
+		t.Errorf("Expected the original message for just its token, got %+v", retry)
+	}
+	retry.To[0] = "changed"
+	if responses[1].PushMessage.To[0] != "ExponentPushToken[b]" {
+		t.Error("Expected the retry message not to share the response's recipients")
+	}
+
+	if retry := (PushResponse{Status: "error"}).RetryMessage(); !reflect.DeepEqual(retry, PushMessage{}) {
+		t.Errorf("Expected a zero message without an attached message, got %+v", retry)
+	}
+}
+
+func TestBackoffSchedule(t *testing.T) {
+	schedule := BackoffSchedule(ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second}, 6)
+	expected := []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second, time.Second,
+	}
+	if len(schedule) != len(expected) {
+		t.Fatalf("Expected %d delays, got %v", len(expected), schedule)
+	}
+	for i, d := range expected {
+		if schedule[i] != d {
+			t.Errorf("Retry %d: expected %s, got %s", i, d, schedule[i])
+		}
+	}
+
+	config := &RetryConfig{MaxRetries: 3, InitialBackoff: time.Second, MaxBackoff: 3 * time.Second}
+	if s := config.Schedule(); len(s) != 3 || s[0] != time.Second || s[1] != 2*time.Second || s[2] != 3*time.Second {
+		t.Errorf("Unexpected config schedule %v", s)
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	backoff := ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	for _, jitter := range []Jitter{EqualJitter, FullJitter} {
+		var requests int32
+		server := newFlakyServer(t, 4, &requests)
+		clock := newFakeClock()
+		client := NewPushClient(&ClientConfig{
+			Host:  server.URL,
+			Clock: clock,
+			Retry: &RetryConfig{MaxRetries: 4, Backoff: backoff, Jitter: jitter, Rand: rand.New(rand.NewSource(1))},
+		})
+		if _, err := client.PublishMultiple(context.Background(), testMessages(1)); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+
+		if len(clock.delays) != 4 {
+			t.Fatalf("Expected 4 retries, got %v", clock.delays)
+		}
+		varied := false
+		for i, d := range clock.delays {
+			max := backoff.Next(i)
+			min := max / 2
+			if jitter == FullJitter {
+				min = 0
+			}
+			if d < min || d > max {
+				t.Errorf("Jitter %d, retry %d: expected a delay between %s and %s, got %s", jitter, i, min, max, d)
+			}
+			varied = varied || d != max
+		}
+		if !varied {
+			t.Errorf("Jitter %d: expected randomized delays, got %v", jitter, clock.delays)
+		}
+	}
+}
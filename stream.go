@@ -0,0 +1,127 @@
+package expo
+
+import (
+	"context"
+)
+
+// FailedResponse is a notification which Expo rejected, with its classification
+type FailedResponse struct {
+	Response PushResponse
+	Err      error // As returned by Response.ValidateResponse
+}
+
+// PublishStream sends the messages received from in until it is closed or
+// ctx is done, sending the messages which are ready together in chunks of up
+// to ClientConfig.ChunkSize notifications. Every response is emitted on the
+// first channel, and the error of each call which failed, as returned by
+// PublishMultiple, on the second. Both channels are closed once in is closed
+// or ctx is done, and the caller must drain both.
+func (c *PushClient) PublishStream(ctx context.Context, in <-chan PushMessage) (<-chan PushResponse, <-chan error) {
+	responses := make(chan PushResponse)
+	errs := make(chan error)
+	go func() {
+		defer close(responses)
+		defer close(errs)
+		c.stream(ctx, in, func(r PushResponse) bool {
+			select {
+			case responses <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}, func(err error) bool {
+			select {
+			case errs <- err:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return responses, errs
+}
+
+// PublishStreamSplit is like PublishStream, but emits successful responses on
+// ok and failed ones on failed, so that they can be handled separately.
+// All three channels are closed once in is closed or ctx is done, and the
+// caller must drain all three.
+func (c *PushClient) PublishStreamSplit(ctx context.Context, in <-chan PushMessage) (<-chan PushResponse, <-chan FailedResponse, <-chan error) {
+	ok := make(chan PushResponse)
+	failed := make(chan FailedResponse)
+	errs := make(chan error)
+	go func() {
+		defer close(ok)
+		defer close(failed)
+		defer close(errs)
+		c.stream(ctx, in, func(r PushResponse) bool {
+			if err := r.ValidateResponse(); err != nil {
+				select {
+				case failed <- FailedResponse{Response: r, Err: err}:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			}
+			select {
+			case ok <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}, func(err error) bool {
+			select {
+			case errs <- err:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return ok, failed, errs
+}
+
+// stream publishes the messages from in, passing each response to onResponse
+// and each error to onError, until in is closed, ctx is done, or a callback
+// returns false
+func (c *PushClient) stream(ctx context.Context, in <-chan PushMessage, onResponse func(PushResponse) bool, onError func(error) bool) {
+	for {
+		var messages []PushMessage
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-in:
+			if !ok {
+				return
+			}
+			messages = append(messages, message)
+		}
+		closed := false
+		// Take the messages which are already waiting, up to a chunk
+	gather:
+		for countNotifications(messages) < c.chunkSize {
+			select {
+			case message, ok := <-in:
+				if !ok {
+					closed = true
+					break gather
+				}
+				messages = append(messages, message)
+			default:
+				break gather
+			}
+		}
+
+		responses, err := c.PublishMultiple(ctx, messages)
+		for _, r := range responses {
+			if !onResponse(r) {
+				return
+			}
+		}
+		if err != nil && !onError(err) {
+			return
+		}
+		if closed {
+			return
+		}
+	}
+}
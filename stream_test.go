@@ -0,0 +1,102 @@
+package expo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newMixedServer returns a server which rejects notifications to tokens
+// containing "gone" as unregistered, fails requests containing "broken" with
+// a 400, and accepts every other notification
+func newMixedServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var messages []PushMessage
+		json.NewDecoder(req.Body).Decode(&messages)
+		data := []PushResponse{}
+		for _, m := range messages {
+			for _, to := range m.To {
+				if strings.Contains(to, "broken") {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if strings.Contains(to, "gone") {
+					data = append(data, PushResponse{Status: "error", Details: map[string]json.RawMessage{"error": []byte(`"DeviceNotRegistered"`)}})
+					continue
+				}
+				data = append(data, PushResponse{ID: "receipt", Status: SuccessStatus})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestPublishStreamSplit(t *testing.T) {
+	server := newMixedServer(t)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, ChunkSize: 2})
+
+	in := make(chan PushMessage)
+	go func() {
+		defer close(in)
+		for _, token := range []string{"a", "gone-1", "b", "c", "gone-2", "broken"} {
+			in <- PushMessage{To: []string{"ExponentPushToken[" + token + "]"}, Body: "hi"}
+		}
+	}()
+	ok, failed, errs := client.PublishStreamSplit(context.Background(), in)
+
+	var wg sync.WaitGroup
+	var oks, fails, errCount int
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for r := range ok {
+			if r.Status != SuccessStatus {
+				t.Errorf("Unexpected status %q on the ok channel", r.Status)
+			}
+			oks++
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for f := range failed {
+			if _, isUnregistered := f.Err.(*DeviceNotRegisteredError); !isUnregistered || !strings.Contains(f.Response.PushMessage.To[0], "gone") {
+				t.Errorf("Unexpected failure %v for %v", f.Err, f.Response.PushMessage.To)
+			}
+			fails++
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range errs {
+			errCount++
+		}
+	}()
+	wg.Wait()
+	if oks != 3 || fails != 2 || errCount != 1 {
+		t.Errorf("Expected 3 ok, 2 failed and 1 error, got %d, %d and %d", oks, fails, errCount)
+	}
+}
+
+func TestPublishStreamCancelled(t *testing.T) {
+	server := newMixedServer(t)
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan PushMessage)
+	responses, errs := client.PublishStream(ctx, in)
+	in <- PushMessage{To: []string{"ExponentPushToken[a]"}}
+	if r := <-responses; r.Status != SuccessStatus {
+		t.Errorf("Unexpected response %+v", r)
+	}
+	cancel()
+	for range responses {
+	}
+	for range errs {
+	}
+}
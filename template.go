@@ -0,0 +1,47 @@
+package expo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// placeholderPattern matches a {{key}} placeholder, capturing the key
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([\w.-]+)\s*\}\}`)
+
+// ErrMissingTemplateVar is returned by RenderTemplateStrict for a placeholder without a value
+var ErrMissingTemplateVar = errors.New("missing template variable")
+
+// RenderTemplate returns a copy of template with the {{key}} placeholders in
+// its Title, Subtitle and Body replaced by the values in vars.
+// Placeholders without a value are left intact; see RenderTemplateStrict.
+func RenderTemplate(template PushMessage, vars map[string]string) PushMessage {
+	message, _ := renderTemplate(template, vars)
+	return message
+}
+
+// RenderTemplateStrict is like RenderTemplate, but returns an error wrapping
+// ErrMissingTemplateVar for each placeholder without a value
+func RenderTemplateStrict(template PushMessage, vars map[string]string) (PushMessage, error) {
+	return renderTemplate(template, vars)
+}
+
+func renderTemplate(template PushMessage, vars map[string]string) (PushMessage, error) {
+	var errs []error
+	render := func(field, s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+			key := placeholderPattern.FindStringSubmatch(placeholder)[1]
+			value, ok := vars[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: %w %q", field, ErrMissingTemplateVar, key))
+				return placeholder
+			}
+			return value
+		})
+	}
+	message := template
+	message.Title = render("Title", template.Title)
+	message.Subtitle = render("Subtitle", template.Subtitle)
+	message.Body = render("Body", template.Body)
+	return message, errors.Join(errs...)
+}
@@ -0,0 +1,41 @@
+package expo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	template := PushMessage{
+		Title:    "Hi {{name}}",
+		Subtitle: "{{ count }} new",
+		Body:     "{{name}}, your order {{order}} shipped",
+		Sound:    "{{name}}",
+	}
+	message := RenderTemplate(template, map[string]string{"name": "Ada", "count": "3", "order": "#42"})
+	if message.Title != "Hi Ada" || message.Subtitle != "3 new" || message.Body != "Ada, your order #42 shipped" {
+		t.Errorf("Unexpected rendering %q, %q, %q", message.Title, message.Subtitle, message.Body)
+	}
+	if message.Sound != "{{name}}" || template.Title != "Hi {{name}}" {
+		t.Error("Expected other fields and the template to be untouched")
+	}
+}
+
+func TestRenderTemplateMissingVars(t *testing.T) {
+	template := PushMessage{Title: "Hi {{name}}", Body: "Order {{order}}"}
+	message := RenderTemplate(template, map[string]string{"name": "Ada"})
+	if message.Title != "Hi Ada" || message.Body != "Order {{order}}" {
+		t.Errorf("Expected unknown placeholders to be left intact, got %q", message.Body)
+	}
+
+	message, err := RenderTemplateStrict(template, map[string]string{"name": "Ada"})
+	if !errors.Is(err, ErrMissingTemplateVar) {
+		t.Errorf("Expected a missing variable error, got %v", err)
+	}
+	if message.Body != "Order {{order}}" {
+		t.Error("Expected the rendered message alongside the error")
+	}
+	if _, err := RenderTemplateStrict(template, map[string]string{"name": "Ada", "order": "1"}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
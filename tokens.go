@@ -0,0 +1,73 @@
+package expo
+
+import (
+	"context"
+	"regexp"
+	"sync"
+)
+
+// strictTokenPattern matches a whole push token with a non-empty identifier.
+// It requires the ExponentPushToken prefix, as sending does.
+var strictTokenPattern = regexp.MustCompile(`^ExponentPushToken\[[^\]]+\]$`)
+
+// FindMalformedTokens returns, in order, the tokens which are not well formed
+// push tokens, such as ExponentPushToken[xxx], for auditing stored tokens
+// before they fail at send time. The check is stricter than
+// NewExponentPushToken, which only checks the prefix, so every token it passes
+// is also accepted when sending.
+func FindMalformedTokens(tokens []string) []string {
+	var malformed []string
+	for _, token := range tokens {
+		if !strictTokenPattern.MatchString(token) {
+			malformed = append(malformed, token)
+		}
+	}
+	return malformed
+}
+
+// validateTokensBatch is the number of tokens a ValidateTokens worker claims at a time
+const validateTokensBatch = 1024
+
+// ValidateTokens checks tokens with NewExponentPushToken across workers
+// goroutines, for lists too large to check serially. The result holds an
+// error for each token at the same index, nil for valid tokens.
+// If ctx is done before every token is checked, the unchecked tokens get ctx's error.
+// Fewer than one worker is treated as one.
+func ValidateTokens(ctx context.Context, tokens []string, workers int) []error {
+	errs := make([]error, len(tokens))
+	if workers < 1 {
+		workers = 1
+	}
+	batches := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range batches {
+				end := start + validateTokensBatch
+				if end > len(tokens) {
+					end = len(tokens)
+				}
+				for i := start; i < end; i++ {
+					_, errs[i] = NewExponentPushToken(tokens[i])
+				}
+			}
+		}()
+	}
+	start := 0
+	for ; start < len(tokens) && ctx.Err() == nil; start += validateTokensBatch {
+		select {
+		case batches <- start:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	close(batches)
+	wg.Wait()
+	for i := start; i < len(tokens); i++ {
+		errs[i] = ctx.Err()
+	}
+	return errs
+}
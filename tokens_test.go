@@ -0,0 +1,85 @@
+package expo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// testTokens returns n tokens, every seventh of which is malformed
+func testTokens(n int) []string {
+	tokens := make([]string, n)
+	for i := range tokens {
+		if i%7 == 0 {
+			tokens[i] = fmt.Sprintf("NotAToken[%d]", i)
+		} else {
+			tokens[i] = fmt.Sprintf("ExponentPushToken[%d]", i)
+		}
+	}
+	return tokens
+}
+
+func TestFindMalformedTokens(t *testing.T) {
+	tokens := []string{
+		"ExponentPushToken[a]",
+		"ExpoPushToken[b]",
+		"NotAToken[c]",
+		"ExponentPushToken[]",
+		"ExponentPushToken[d] ",
+		"ExponentPushTokenE",
+		"",
+		"ExponentPushToken[f]",
+	}
+	expected := []string{"ExpoPushToken[b]", "NotAToken[c]", "ExponentPushToken[]", "ExponentPushToken[d] ", "ExponentPushTokenE", ""}
+	malformed := FindMalformedTokens(tokens)
+	if !reflect.DeepEqual(malformed, expected) {
+		t.Errorf("Expected %q, got %q", expected, malformed)
+	}
+	// Every token passing the audit must also pass when sending
+	flagged := map[string]bool{}
+	for _, token := range malformed {
+		flagged[token] = true
+	}
+	client := NewPushClient(nil)
+	for _, token := range tokens {
+		if _, err := client.validate([]PushMessage{{To: []string{token}}}); !flagged[token] && err != nil {
+			t.Errorf("%q passed the audit but fails to send: %v", token, err)
+		}
+	}
+}
+
+func TestValidateTokensMatchesSerial(t *testing.T) {
+	tokens := testTokens(5000)
+	errs := ValidateTokens(context.Background(), tokens, 4)
+	if len(errs) != len(tokens) {
+		t.Fatalf("Expected %d results, got %d", len(tokens), len(errs))
+	}
+	for i, token := range tokens {
+		_, expected := NewExponentPushToken(token)
+		if errs[i] != expected {
+			t.Fatalf("Token %d: expected %v, got %v", i, expected, errs[i])
+		}
+	}
+}
+
+func TestValidateTokensCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs := ValidateTokens(ctx, testTokens(5000), 4)
+	if !errors.Is(errs[len(errs)-1], context.Canceled) {
+		t.Errorf("Expected unchecked tokens to get the context's error, got %v", errs[len(errs)-1])
+	}
+}
+
+func BenchmarkValidateTokens(b *testing.B) {
+	tokens := testTokens(1000000)
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ValidateTokens(context.Background(), tokens, workers)
+			}
+		})
+	}
+}
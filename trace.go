@@ -0,0 +1,93 @@
+package expo
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// RequestTiming breaks down where the time of a push request went, recorded
+// with ClientConfig.TraceRequests. Phases which didn't happen, such as DNS,
+// connect and TLS on a reused connection, are zero.
+type RequestTiming struct {
+	DNS       time.Duration // Resolving the host
+	Connect   time.Duration // Establishing the TCP connection
+	TLS       time.Duration // The TLS handshake
+	FirstByte time.Duration // From writing the request to the first response byte
+	Reused    bool          // Whether an idle connection was reused
+}
+
+// requestTrace collects the timing of a single request from httptrace hooks,
+// which may fire on other goroutines
+type requestTrace struct {
+	mu                               sync.Mutex
+	timing                           RequestTiming
+	dnsStart, connectStart, tlsStart time.Time
+	wrote                            time.Time
+}
+
+// traceRequest returns req with hooks recording its timing, and the trace to read it from
+func traceRequest(req *http.Request) (*http.Request, *requestTrace) {
+	t := &requestTrace{}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.Reused = info.Reused
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.DNS = time.Since(t.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.Connect = time.Since(t.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.TLS = time.Since(t.tlsStart)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wrote = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.FirstByte = time.Since(t.wrote)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), t
+}
+
+// result returns the recorded timing, or nil if the request wasn't traced
+func (t *requestTrace) result() *RequestTiming {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := t.timing
+	return &timing
+}
@@ -0,0 +1,46 @@
+package expo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraceRequests(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		acceptAll(t, w, req)
+	}))
+	defer server.Close()
+	httpClient := server.Client()
+	transport := httpClient.Transport.(*http.Transport)
+	transport.DisableKeepAlives = true
+	transport.TLSClientConfig.ServerName = "example.com" // Covered by the test certificate
+	client := NewPushClient(&ClientConfig{
+		// Name the host so that it is resolved
+		Host:          strings.Replace(server.URL, "127.0.0.1", "localhost", 1),
+		HTTPClient:    httpClient,
+		TraceRequests: true,
+	})
+
+	responses, err := client.PublishMultiple(context.Background(), testMessages(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timing := responses[0].Timing
+	if timing == nil || responses[1].Timing != timing {
+		t.Fatalf("Expected the request's timing on each response, got %v", timing)
+	}
+	if timing.DNS <= 0 || timing.Connect <= 0 || timing.TLS <= 0 || timing.FirstByte <= 0 || timing.Reused {
+		t.Errorf("Expected every phase of a new connection to be timed, got %+v", *timing)
+	}
+
+	responses, err = NewPushClient(&ClientConfig{Host: server.URL, HTTPClient: httpClient}).PublishMultiple(context.Background(), testMessages(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].Timing != nil {
+		t.Error("Expected no timing unless tracing is enabled")
+	}
+}
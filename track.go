@@ -0,0 +1,128 @@
+package expo
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// TrackedReceipt is the receipt of a notification sent by PublishAndTrack
+type TrackedReceipt struct {
+	ID      string      // Receipt ID from the push response
+	Token   string      // Push token the notification was sent to
+	Receipt PushReceipt // The resolved receipt
+	Err     error       // Classification of the receipt, as returned by ValidateReceipt
+}
+
+// ReceiptTracker resolves the receipts of notifications sent by PublishAndTrack
+type ReceiptTracker struct {
+	// Responses of the notifications Expo accepted or rejected at send time
+	Responses []PushResponse
+
+	done     chan struct{}
+	receipts []TrackedReceipt
+	err      error
+}
+
+// Done is closed once polling has finished
+func (t *ReceiptTracker) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait blocks until polling has finished or ctx is done. It returns the
+// resolved receipts in the order of Responses and the polling error, if any;
+// receipts which never resolved are absent.
+func (t *ReceiptTracker) Wait(ctx context.Context) ([]TrackedReceipt, error) {
+	select {
+	case <-t.done:
+		return t.receipts, t.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// PublishAndTrack sends messages like PublishMultiple, then polls for the
+// receipts of the accepted notifications in the background with PollReceipts.
+// With ClientConfig.TokenRouter, the receipts of each access token are polled
+// with that token, since Expo only returns a receipt to the project which sent it.
+// Polling stops when ctx is done.
+// The returned tracker is nil only if the messages failed validation; otherwise the error
+// is the PublishMultiple error for any failed chunks, whose notifications
+// aren't tracked.
+func (c *PushClient) PublishAndTrack(ctx context.Context, messages []PushMessage, pollCfg *PollConfig) (*ReceiptTracker, error) {
+	responses, err := c.PublishMultiple(ctx, messages)
+	if responses == nil {
+		return nil, err
+	}
+	var ids, tokens []string
+	idsByAccessToken := map[string][]string{}
+	for _, r := range responses {
+		if r.ID != "" && r.isSuccess() {
+			ids = append(ids, r.ID)
+			tokens = append(tokens, r.PushMessage.To[0])
+			idsByAccessToken[r.accessToken] = append(idsByAccessToken[r.accessToken], r.ID)
+		}
+	}
+	tracker := &ReceiptTracker{Responses: responses, done: make(chan struct{})}
+	go func() {
+		defer close(tracker.done)
+		if len(ids) == 0 {
+			return
+		}
+		receipts, err := c.pollByAccessToken(ctx, idsByAccessToken, pollCfg)
+		tracker.err = err
+		for i, id := range ids {
+			if receipt, ok := receipts[id]; ok {
+				tracker.receipts = append(tracker.receipts, TrackedReceipt{
+					ID:      id,
+					Token:   tokens[i],
+					Receipt: receipt,
+					Err:     receipt.ValidateReceipt(),
+				})
+			}
+		}
+	}()
+	return tracker, err
+}
+
+// pollByAccessToken polls the receipts of each group of IDs with the access
+// token they were sent with, concurrently, merging the receipts and joining the errors
+func (c *PushClient) pollByAccessToken(ctx context.Context, idsByAccessToken map[string][]string, pollCfg *PollConfig) (map[string]PushReceipt, error) {
+	if len(idsByAccessToken) == 1 {
+		for accessToken, ids := range idsByAccessToken {
+			return c.withAccessToken(accessToken).PollReceipts(ctx, ids, pollCfg)
+		}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	receipts := map[string]PushReceipt{}
+	var errs []error
+	for accessToken, ids := range idsByAccessToken {
+		wg.Add(1)
+		go func(client *PushClient, ids []string) {
+			defer wg.Done()
+			polled, err := client.PollReceipts(ctx, ids, pollCfg)
+			mu.Lock()
+			defer mu.Unlock()
+			for id, receipt := range polled {
+				receipts[id] = receipt
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}(c.withAccessToken(accessToken), ids)
+	}
+	wg.Wait()
+	return receipts, errors.Join(errs...)
+}
+
+// withAccessToken returns a copy of the client authorized with accessToken,
+// or the client itself if that is its token already
+func (c *PushClient) withAccessToken(accessToken string) *PushClient {
+	if accessToken == c.accessToken {
+		return c
+	}
+	clone := *c
+	clone.accessToken = accessToken
+	return &clone
+}
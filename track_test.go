@@ -0,0 +1,133 @@
+package expo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPublishAndTrack(t *testing.T) {
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/--/api/v2/push/send":
+			var messages []PushMessage
+			json.NewDecoder(req.Body).Decode(&messages)
+			data := []PushResponse{}
+			for _, m := range messages {
+				data = append(data, PushResponse{ID: "id-" + m.To[0], Status: SuccessStatus})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		case "/--/api/v2/push/getReceipts":
+			var body receiptsRequest
+			json.NewDecoder(req.Body).Decode(&body)
+			data := map[string]PushReceipt{}
+			// Resolve the receipts on the second poll
+			if atomic.AddInt32(&polls, 1) > 1 {
+				for _, id := range body.IDs {
+					data[id] = PushReceipt{Status: SuccessStatus}
+				}
+				data["id-ExponentPushToken[1]"] = PushReceipt{
+					Status:  "error",
+					Details: map[string]json.RawMessage{"error": []byte(`"DeviceNotRegistered"`)},
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		default:
+			t.Errorf("Unexpected path %s", req.URL.Path)
+		}
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{Host: server.URL, Clock: newFakeClock()})
+
+	tracker, err := client.PublishAndTrack(context.Background(), testMessages(3), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracker.Responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %d", len(tracker.Responses))
+	}
+	receipts, err := tracker.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 3 || polls != 2 {
+		t.Fatalf("Expected 3 receipts after 2 polls, got %d after %d", len(receipts), polls)
+	}
+	for i, r := range receipts {
+		token := fmt.Sprintf("ExponentPushToken[%d]", i)
+		if r.Token != token || r.ID != "id-"+token {
+			t.Errorf("Receipt %d isn't mapped to its token: %+v", i, r)
+		}
+	}
+	if _, ok := receipts[1].Err.(*DeviceNotRegisteredError); !ok {
+		t.Errorf("Expected the receipt to be classified, got %v", receipts[1].Err)
+	}
+	if receipts[0].Err != nil || receipts[2].Err != nil {
+		t.Error("Expected ok receipts to have no error")
+	}
+}
+
+func TestPublishAndTrackTokenRouter(t *testing.T) {
+	var mu sync.Mutex
+	sentWith := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.URL.Path {
+		case "/--/api/v2/push/send":
+			var messages []PushMessage
+			json.NewDecoder(req.Body).Decode(&messages)
+			data := []PushResponse{}
+			for _, m := range messages {
+				sentWith["id-"+m.To[0]] = auth
+				data = append(data, PushResponse{ID: "id-" + m.To[0], Status: SuccessStatus})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		case "/--/api/v2/push/getReceipts":
+			var body receiptsRequest
+			json.NewDecoder(req.Body).Decode(&body)
+			// Like Expo, only return receipts to the project which sent them
+			data := map[string]PushReceipt{}
+			for _, id := range body.IDs {
+				if sentWith[id] == auth {
+					data[id] = PushReceipt{Status: SuccessStatus}
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		}
+	}))
+	defer server.Close()
+	client := NewPushClient(&ClientConfig{
+		Host:        server.URL,
+		Clock:       newFakeClock(),
+		AccessToken: "default-token",
+		TokenRouter: func(m PushMessage) string {
+			if m.To[0] == "ExponentPushToken[1]" {
+				return "token-a"
+			}
+			return ""
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	tracker, err := client.PublishAndTrack(ctx, testMessages(3), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipts, err := tracker.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 3 {
+		t.Errorf("Expected each receipt to be polled with its access token, got %d receipts", len(receipts))
+	}
+}